@@ -0,0 +1,154 @@
+package vech
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// IndexType defines approximate nearest neighbour index built for sealed segments
+type IndexType int
+
+const (
+	FlatIndex IndexType = iota // no index, exhaustive scan
+	IVFIndex                   // inverted file index over k-means clusters
+)
+
+const ivfIterations = 10
+
+// ivfIndex splits the segment vectors into clusters, the search scans only the clusters closest to the query
+type ivfIndex struct {
+	Centroids [][]float32
+	Lists     [][]int // local record numbers of every cluster
+	Metric    Metric  // metric of clustering and probing, indexes saved without it are cosine
+}
+
+// buildIVF clusters n vectors with k-means by the metric, nlists 0 means square root of n.
+// Centroids of angular metrics are normalized, so the clustering is spherical.
+func buildIVF(vector func(i int) []float32, n, nlists int, metric Metric) *ivfIndex {
+	if nlists <= 0 {
+		nlists = int(math.Sqrt(float64(n)))
+	}
+	nlists = min(nlists, n)
+	if nlists == 0 {
+		return nil
+	}
+	point := func(v []float32) []float32 {
+		if metric == Euclidean {
+			return slices.Clone(v)
+		}
+		return normalized(v)
+	}
+	ix := &ivfIndex{Centroids: make([][]float32, nlists), Metric: metric}
+	for i := range ix.Centroids {
+		ix.Centroids[i] = point(vector(i * n / nlists))
+	}
+	assign := make([]int, n)
+	for iter := 0; iter < ivfIterations; iter++ {
+		changed := false
+		for i := 0; i < n; i++ {
+			best := ix.nearest(vector(i))
+			if iter == 0 || best != assign[i] {
+				assign[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		sums := make([][]float32, nlists)
+		counts := make([]int, nlists)
+		for i := 0; i < n; i++ {
+			v := point(vector(i))
+			counts[assign[i]]++
+			if sums[assign[i]] == nil {
+				sums[assign[i]] = v
+				continue
+			}
+			for j, x := range v {
+				sums[assign[i]][j] += x
+			}
+		}
+		for i, s := range sums {
+			if s == nil { // empty cluster keeps the previous centroid
+				continue
+			}
+			if metric != Euclidean {
+				ix.Centroids[i] = normalized(s)
+				continue
+			}
+			for j := range s {
+				s[j] /= float32(counts[i])
+			}
+			ix.Centroids[i] = s
+		}
+	}
+	ix.Lists = make([][]int, nlists)
+	for i, a := range assign {
+		ix.Lists[a] = append(ix.Lists[a], i)
+	}
+	return ix
+}
+
+// candidates returns local record numbers of nprobes clusters closest to the vector
+func (ix *ivfIndex) candidates(vector []float32, nprobes int) []int {
+	if nprobes <= 0 {
+		nprobes = max(1, len(ix.Centroids)/4)
+	}
+	res := make([]Distance, len(ix.Centroids))
+	for i, c := range ix.Centroids {
+		res[i] = Distance{N: i, Value: ix.Metric.distance(vector, c)}
+	}
+	sortDistances(res, ix.Metric.closest())
+	var out []int
+	for _, d := range res[:min(nprobes, len(res))] {
+		out = append(out, ix.Lists[d.N]...)
+	}
+	return out
+}
+
+// nearest returns the cluster closest to the vector
+func (ix *ivfIndex) nearest(v []float32) int {
+	best, bestValue := 0, ix.Metric.distance(v, ix.Centroids[0])
+	for i, c := range ix.Centroids[1:] {
+		value := ix.Metric.distance(v, c)
+		if ix.Metric.closest() == SortAsc && value < bestValue || ix.Metric.closest() == SortDesc && value > bestValue {
+			best, bestValue = i+1, value
+		}
+	}
+	return best
+}
+
+func normalized(v []float32) []float32 {
+	out := make([]float32, len(v))
+	var s float64
+	for _, x := range v {
+		s += float64(x) * float64(x)
+	}
+	if s == 0 {
+		return out
+	}
+	norm := float32(math.Sqrt(s))
+	for i, x := range v {
+		out[i] = x / norm
+	}
+	return out
+}
+
+func encodeIVF(ix *ivfIndex) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(ix); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeIVF(data []byte) (*ivfIndex, error) {
+	var ix ivfIndex
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&ix); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptedDb, err.Error())
+	}
+	return &ix, nil
+}
@@ -0,0 +1,69 @@
+package vech
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestBuildIVF(t *testing.T) {
+	data := randomChunks(100, 6)
+	vector := func(i int) []float32 { return data[i].vector }
+	ix := buildIVF(vector, len(data), 0, Cosine)
+	if len(ix.Centroids) != 10 {
+		t.Fatalf("amount of clusters expected to be 10, actual: %d", len(ix.Centroids))
+	}
+	var all []int
+	for _, l := range ix.Lists {
+		all = append(all, l...)
+	}
+	sort.Ints(all)
+	for i, n := range all {
+		if i != n {
+			t.Fatalf("every record expected to be in exactly one cluster, record %d is missing", i)
+		}
+	}
+	if len(ix.candidates(data[0].vector, 10)) != len(data) {
+		t.Fatal("probing all clusters expected to return all records")
+	}
+	if len(ix.candidates(data[0].vector, 1)) == len(data) {
+		t.Fatal("probing one cluster expected to return part of records")
+	}
+
+	enc, err := encodeIVF(ix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := decodeIVF(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dec.Lists) != len(ix.Lists) || len(dec.Centroids[0]) != 6 {
+		t.Fatal("decoded index does not match to original")
+	}
+
+	if buildIVF(vector, 0, 0, Cosine) != nil {
+		t.Fatal("index of empty segment expected to be nil")
+	}
+}
+
+func TestBuildIVFEuclidean(t *testing.T) {
+	// groups of the same direction are separated only by the distance
+	var data [][]float32
+	for _, scale := range []float32{1, 10, 100} {
+		for i := range 10 {
+			data = append(data, []float32{scale + float32(i)*0.01, scale * 0.1})
+		}
+	}
+	vector := func(i int) []float32 { return data[i] }
+	ix := buildIVF(vector, len(data), 3, Euclidean)
+	for _, l := range ix.Lists {
+		if len(l) != 10 || l[0]/10 != l[9]/10 {
+			t.Fatalf("clusters expected to match the groups, lists: %v", ix.Lists)
+		}
+	}
+	res := ix.candidates([]float32{99, 9}, 1)
+	sort.Ints(res)
+	if len(res) != 10 || res[0] != 20 {
+		t.Fatalf("probe expected to return the closest group, actual: %v", res)
+	}
+}
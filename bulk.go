@@ -0,0 +1,107 @@
+package vech
+
+import "fmt"
+
+// defaultBulkBuffer is the flush threshold of BulkWriter created with zero buffer size
+const defaultBulkBuffer = 4 << 20
+
+// BulkWriter buffers added records and writes them to the collection in batches,
+// so every batch costs single write of the index and single write of the data.
+// The batch is atomic: after a crash either all its records are in the collection or none of them.
+// Buffered records are not visible to readers until they are flushed.
+// BulkWriter is not safe for concurrent use, but the collection may be used while it is writing.
+type BulkWriter struct {
+	c          *Collection
+	comp       *compressor
+	bufferSize int
+	index      []byte
+	data       []byte
+}
+
+// BulkWriter returns the writer flushing records when buffered index and data exceed bufferSize bytes,
+// 0 means 4MB. The last batch has to be written by Flush or Sync.
+func (c *Collection) BulkWriter(bufferSize int) *BulkWriter {
+	if bufferSize <= 0 {
+		bufferSize = defaultBulkBuffer
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return &BulkWriter{c: c, comp: c.newCompressor(), bufferSize: bufferSize}
+}
+
+// Add buffers the record and flushes the buffer when it is full
+func (w *BulkWriter) Add(vector []float32, data []byte) error {
+	return w.add(vector, data, 0)
+}
+
+func (w *BulkWriter) add(vector []float32, data []byte, flags int) error {
+	if len(vector) != w.c.vectorSize {
+		return fmt.Errorf("%w: collection vector size: %d, provided vector size: %d", ErrVectorSize, w.c.vectorSize, len(vector))
+	}
+	data, compressed, err := w.comp.compress(data)
+	if err != nil {
+		return err
+	}
+	w.index = appendRecord(w.index, len(w.data), len(data), flags|compressed, w.c.encoding, vector)
+	w.data = append(w.data, data...)
+	if len(w.index)+len(w.data) >= w.bufferSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Buffered returns amount of records waiting for flush
+func (w *BulkWriter) Buffered() int {
+	return len(w.index) / (w.c.vectorSize*w.c.encoding.size() + 16)
+}
+
+// Flush writes buffered records as one batch, the batch survives the crash of the process
+func (w *BulkWriter) Flush() error {
+	return w.flush(false)
+}
+
+// Sync writes buffered records as one batch and commits collection storages to stable storage,
+// so the written batches survive the crash of the system
+func (w *BulkWriter) Sync() error {
+	return w.flush(true)
+}
+
+func (w *BulkWriter) flush(durable bool) error {
+	if len(w.index) == 0 {
+		if durable {
+			return w.c.sync()
+		}
+		return nil
+	}
+	if err := w.c.addBatch(w.index, w.data, durable); err != nil {
+		return err
+	}
+	w.index = w.index[:0]
+	w.data = w.data[:0]
+	return nil
+}
+
+// addBatch appends encoded records to the active segment, the segment is sealed when it is full
+func (c *Collection) addBatch(index, data []byte, durable bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seg := c.active()
+	if err := seg.addBatch(c.backend, c.name, index, data, durable); err != nil {
+		return err
+	}
+	if c.segmentSize > 0 && seg.size() >= c.segmentSize {
+		return c.seal()
+	}
+	return nil
+}
+
+// sync commits the active segment to stable storage, sealed segments are synced by seal
+func (c *Collection) sync() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seg := c.active()
+	if err := seg.dataStorage.sync(); err != nil {
+		return err
+	}
+	return seg.indexStorage.sync()
+}
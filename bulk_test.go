@@ -0,0 +1,198 @@
+package vech
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBulkWriter(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 8, Path: path, SegmentSize: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomChunks(95, 8)
+	w := c.BulkWriter(500)
+	for i, d := range data {
+		if err = w.Add(d.vector, d.data); err != nil {
+			t.Fatal(err)
+		}
+		if c.Len()+w.Buffered() != i+1 {
+			t.Fatalf("expected %d records written or buffered, actual: %d and %d", i+1, c.Len(), w.Buffered())
+		}
+	}
+	if w.Buffered() == 0 || c.Len() == 0 {
+		t.Fatalf("records expected to be partially flushed, buffered: %d, written: %d", w.Buffered(), c.Len())
+	}
+	if err = w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Buffered() != 0 {
+		t.Fatalf("buffer expected to be empty after sync, actual: %d", w.Buffered())
+	}
+	checkChunks(t, c, data)
+	if c.Segments() < 2 {
+		t.Fatalf("batches expected to seal segments, segments: %d", c.Segments())
+	}
+	if err = w.Add([]float32{1}, nil); !errors.Is(err, ErrVectorSize) {
+		t.Fatalf("error expected to be ErrVectorSize, returned: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenFileDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c, err = db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, data)
+}
+
+func TestBatchRecovery(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = addChunks(c, chunks); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	idxSize, dataSize := fileSize(t, path+"/foo.idx"), fileSize(t, path+"/foo.data")
+
+	// batch interrupted after two records and part of the data were written
+	var buf bytes.Buffer
+	if err = gob.NewEncoder(&buf).Encode(batchMarker{IndexSize: idxSize, DataSize: dataSize}); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path+"/foo"+batchExt, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	index := appendRecord(nil, dataSize, 2, 0, Float32, chunks[0].vector)
+	index = appendRecord(index, dataSize+2, 2, 0, Float32, chunks[1].vector)
+	appendFile(t, path+"/foo.idx", index)
+	appendFile(t, path+"/foo.data", []byte{1, 2, 3})
+
+	ro, err := OpenDb(&OpenDbOptions{Path: path, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = ro.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, chunks)
+	ro.Close()
+
+	// follower neither opening nor refreshing rolls back the batch the writer may still be writing
+	follower, err := OpenDb(&OpenDbOptions{Path: path, Follow: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = follower.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, chunks)
+	follower.Close()
+	if size := fileSize(t, path+"/foo.idx"); size != idxSize+len(index) {
+		t.Fatalf("index of the batch expected to be kept by follower, size: %d", size)
+	}
+	if _, err = os.Stat(path + "/foo" + batchExt); err != nil {
+		t.Fatalf("batch marker expected to be kept by follower, stat returned: %v", err)
+	}
+
+	// writable instance without the exclusive lock hides the batch and refuses to append after it
+	unlocked, err := OpenDb(&OpenDbOptions{Path: path, Lock: LockNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = unlocked.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, chunks)
+	if err = addChunks(c, chunks[:1]); !errors.Is(err, ErrNotRecovered) {
+		t.Fatalf("error expected to be ErrNotRecovered, returned: %v", err)
+	}
+	unlocked.Close()
+	if size := fileSize(t, path+"/foo.idx"); size != idxSize+len(index) {
+		t.Fatalf("index of the batch expected to be kept without exclusive lock, size: %d", size)
+	}
+	if _, err = os.Stat(path + "/foo" + batchExt); err != nil {
+		t.Fatalf("batch marker expected to be kept without exclusive lock, stat returned: %v", err)
+	}
+
+	db, err = OpenFileDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c, err = db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, chunks)
+	if size := fileSize(t, path+"/foo.idx"); size != idxSize {
+		t.Fatalf("index expected to be truncated to %d, actual size: %d", idxSize, size)
+	}
+	if size := fileSize(t, path+"/foo.data"); size != dataSize {
+		t.Fatalf("data expected to be truncated to %d, actual size: %d", dataSize, size)
+	}
+	if _, err = os.Stat(path + "/foo" + batchExt); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("batch marker expected to be removed, stat returned: %v", err)
+	}
+	if err = c.Add(chunks[0].vector, chunks[0].data); err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, append(chunks[:len(chunks):len(chunks)], chunks[0]))
+}
+
+func fileSize(t *testing.T, path string) int {
+	t.Helper()
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(stat.Size())
+}
+
+func appendFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,7 +1,11 @@
 package vech
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math"
+	"sync"
 )
 
 var (
@@ -9,122 +13,454 @@ var (
 	ErrIndexOutOfRange = errors.New("index out of range")
 	ErrDataPosition    = errors.New("data position is not wrong")
 	ErrReadData        = errors.New("error reading data")
+	ErrDeleted         = errors.New("record is deleted")
+	ErrBatchSize       = errors.New("batch items count mismatch")
+	ErrNotRecovered    = errors.New("interrupted write is not recovered without exclusive lock")
 )
 
 // IndexRecord represents the data containing in the index
 type IndexRecord struct {
 	Position int       // data position from the start of the storage
-	Size     int       // data length
+	Size     int       // stored data length, it differs from the payload length for compressed records
 	Vector   []float32 // vector
 }
 
-// Collection represents single collection
+// Collection represents single collection.
+// Collection is safe for concurrent use: any number of goroutines may search and read records
+// while other goroutines add or delete records.
 type Collection struct {
-	indexStorage storage
-	dataStorage  storage
+	name         string
+	backend      backend
 	vectorSize   int
-	recordSize   int
-	dataSize     int
-	index        []byte
+	metric       Metric
+	encoding     Encoding
+	textField    string        // record field indexed for text search, empty if there is no text index
+	named        []NamedVector // named vectors of the records
+	segmentSize  int
+	indexType    IndexType
+	ivfLists     int
+	ivfProbes    int
+	codec        Codec
+	level        int
+	dictionaries [][]byte     // compression dictionaries, appended only
+	comp         *compressor  // compressor used by Add
+	segments     []*segment   // the last segment is active
+	nextID       int          // id of the next created segment
+	recovers     bool         // interrupted writes are rolled back by the instance holding the exclusive lock
+	mu           sync.RWMutex // guards segments, Add and Delete take write lock, readers share read lock
+	mergeMu      sync.Mutex   // serializes merges
+	pinMu        sync.Mutex   // guards pins of segments
+
+	release      func() // unregisters collection from the database on close
+	stopMerging  context.CancelFunc
+	sealedNotify chan struct{}
+	mergeDone    chan struct{}
+
+	stopFollowing context.CancelFunc
+	followDone    chan struct{}
+}
+
+func openCollection(b backend, name string, cfg *config, recovers bool) (*Collection, error) {
+	m, err := readManifest(b, name)
+	if err != nil {
+		return nil, err
+	}
+	opt := m.options(cfg)
+	c := Collection{
+		name:         name,
+		backend:      b,
+		vectorSize:   opt.VectorSize,
+		metric:       opt.Metric,
+		encoding:     opt.Encoding,
+		textField:    opt.TextField,
+		named:        opt.Vectors,
+		segmentSize:  cfg.SegmentSize,
+		indexType:    opt.IndexType,
+		ivfLists:     opt.IVFLists,
+		ivfProbes:    opt.IVFProbes,
+		codec:        m.Codec,
+		level:        m.Level,
+		dictionaries: m.Dictionaries,
+		recovers:     recovers,
+	}
+	c.comp = c.newCompressor()
+	base, dataBase := 0, 0
+	for i, id := range m.Segments {
+		seg, err := openSegment(b, name, id, c.vectorSize, c.encoding, recovers)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		seg.base = base
+		seg.dataBase = dataBase
+		seg.sealed = i < len(m.Segments)-1
+		c.segments = append(c.segments, seg)
+		c.nextID = max(c.nextID, id+1)
+		base += seg.len()
+		dataBase += seg.dataSize
+		if seg.sealed && c.indexType == IVFIndex {
+			if err := c.loadOrBuildANN(seg); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+	}
+	return &c, nil
+}
+
+func (c *Collection) loadOrBuildANN(seg *segment) error {
+	ok, err := seg.loadANN(c.backend, c.name)
+	if err != nil || ok {
+		return err
+	}
+	seg.ann = buildIVF(seg.vector, seg.len(), c.ivfLists, c.metric)
+	return nil
 }
 
-// Len returns amount of records in collection
+func (c *Collection) active() *segment {
+	return c.segments[len(c.segments)-1]
+}
+
+// Len returns amount of records in collection including deleted ones
 func (c *Collection) Len() int {
-	return len(c.index) / c.recordSize
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.len()
+}
+
+func (c *Collection) len() int {
+	a := c.active()
+	return a.base + a.len()
+}
+
+// Segments returns amount of segments in collection
+func (c *Collection) Segments() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.segments)
+}
+
+// rebase recalculates segments positions in collection after segments list change
+func (c *Collection) rebase() {
+	base, dataBase := 0, 0
+	for _, s := range c.segments {
+		s.base = base
+		s.dataBase = dataBase
+		base += s.len()
+		dataBase += s.dataSize
+	}
 }
 
 func (c *Collection) Add(vector []float32, data []byte) error {
-	ln := len(c.index)
-	end := ln + c.recordSize
-	vecbytes := float32SliceToByte(vector)
-	dataStart := c.dataSize
-	dataLen := len(data)
-
-	if cap(c.index) >= end {
-		c.index = c.index[:end] //expand without copy/alloc
-		intToBytes(dataStart, c.index[ln:])
-		intToBytes(dataLen, c.index[ln+8:])
-		copy(c.index[ln+16:], vecbytes)
-	} else {
-		head := make([]byte, 16)
-		intToBytes(c.dataSize, head)
-		intToBytes(len(data), head[8:])
-		c.index = append(c.index, head...)
-		c.index = append(c.index, vecbytes...)
-	}
-	idxWriter, err := c.indexStorage.writer()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.add(vector, data, 0)
+}
+
+// AddMany adds records of vectors with corresponding data, data may be nil if records have no data.
+// The records are written as one atomic batch, see BulkWriter. The amount of added records is returned,
+// it is either all records or none of them when an error occurs or the context is done.
+func (c *Collection) AddMany(ctx context.Context, vectors [][]float32, data [][]byte) (int, error) {
+	if data != nil && len(data) != len(vectors) {
+		return 0, fmt.Errorf("%w: %d vectors, %d data items", ErrBatchSize, len(vectors), len(data))
+	}
+	w := c.BulkWriter(math.MaxInt)
+	for i, vector := range vectors {
+		if i%cancelCheckStep == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+		var d []byte
+		if data != nil {
+			d = data[i]
+		}
+		if err := w.Add(vector, d); err != nil {
+			return 0, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return len(vectors), nil
+}
+
+func (c *Collection) add(vector []float32, data []byte, flags int) error {
+	if len(vector) != c.vectorSize {
+		return fmt.Errorf("%w: collection vector size: %d, provided vector size: %d", ErrVectorSize, c.vectorSize, len(vector))
+	}
+	data, compressed, err := c.comp.compress(data)
 	if err != nil {
 		return err
 	}
-	if _, err = idxWriter.Write(c.index[ln:]); err != nil {
+	seg := c.active()
+	if err := seg.add(vector, data, flags|compressed); err != nil {
+		return err
+	}
+	if c.segmentSize > 0 && seg.size() >= c.segmentSize {
+		return c.seal()
+	}
+	return nil
+}
+
+// seal makes the active segment immutable and starts the new one
+func (c *Collection) seal() error {
+	seg := c.active()
+	// sealed segment is never written again, so batches flushed to it are made durable here
+	if err := seg.indexStorage.sync(); err != nil {
+		return err
+	}
+	if err := seg.dataStorage.sync(); err != nil {
 		return err
 	}
-	dataWriter, err := c.dataStorage.writer()
+	if err := seg.indexStorage.closeWriter(); err != nil {
+		return err
+	}
+	if err := seg.dataStorage.closeWriter(); err != nil {
+		return err
+	}
+	seg.sealed = true
+	if c.indexType == IVFIndex {
+		if err := seg.buildANN(c.backend, c.name, c.ivfLists, c.metric); err != nil {
+			return err
+		}
+	}
+	next, err := c.newSegment()
 	if err != nil {
 		return err
 	}
-	if _, err = dataWriter.Write(data); err != nil {
+	next.base = seg.base + seg.len()
+	next.dataBase = seg.dataBase + seg.dataSize
+	c.segments = append(c.segments, next)
+	if err := writeManifest(c.backend, c.name, c.manifest()); err != nil {
 		return err
 	}
-	c.dataSize += dataLen
+	if c.sealedNotify != nil {
+		select {
+		case c.sealedNotify <- struct{}{}:
+		default:
+		}
+	}
 	return nil
 }
 
-func (c *Collection) Index(n int) (*IndexRecord, error) {
+// newSegment creates empty segment with unused id, leftovers of interrupted operations are removed
+func (c *Collection) newSegment() (*segment, error) {
+	id := c.nextID
+	c.nextID++
+	if err := removeSegment(c.backend, c.name, id); err != nil {
+		return nil, err
+	}
+	return openSegment(c.backend, c.name, id, c.vectorSize, c.encoding, c.recovers)
+}
+
+func (c *Collection) manifest() *manifest {
+	m := manifest{
+		Segments:     make([]int, len(c.segments)),
+		Codec:        c.codec,
+		Level:        c.level,
+		Dictionaries: c.dictionaries,
+		Options:      c.options(),
+	}
+	for i, s := range c.segments {
+		m.Segments[i] = s.id
+	}
+	return &m
+}
+
+// segmentOf returns the segment containing record n
+func (c *Collection) segmentOf(n int) (*segment, error) {
 	if n < 0 {
 		return nil, ErrIndexOutOfRange
 	}
-	var ret IndexRecord
+	for _, s := range c.segments {
+		if n < s.base+s.len() {
+			return s, nil
+		}
+	}
+	return nil, ErrIndexOutOfRange
+}
 
-	start := c.recordSize * n
-	end := start + 16
-	if end > len(c.index) {
-		return nil, ErrIndexOutOfRange
+// pin keeps the segments open while they are used outside the collection lock, c.mu has to be held
+func (c *Collection) pin(segments []*segment) {
+	c.pinMu.Lock()
+	defer c.pinMu.Unlock()
+	for _, s := range segments {
+		s.pins++
 	}
-	ret.Position = bytesToInt(c.index[start : start+8])
-	ret.Size = bytesToInt(c.index[start+8 : start+16])
+}
 
-	start = end
-	end = start + c.vectorSize*4
-	if end > len(c.index) {
-		return nil, ErrIndexOutOfRange
+// unpin releases the pinned segments, the retired ones are dropped by the last user
+func (c *Collection) unpin(segments []*segment) {
+	c.pinMu.Lock()
+	var dropped []*segment
+	for _, s := range segments {
+		s.pins--
+		if s.pins == 0 && s.retired {
+			dropped = append(dropped, s)
+		}
+	}
+	c.pinMu.Unlock()
+	for _, s := range dropped {
+		c.drop(s)
+	}
+}
+
+// retire drops the segments removed from the collection, pinned segments are dropped when they are unpinned.
+// Files are removed by the writer, followers only close the segments.
+func (c *Collection) retire(segments []*segment, remove bool) {
+	c.pinMu.Lock()
+	var dropped []*segment
+	for _, s := range segments {
+		s.retired, s.remove = true, remove
+		if s.pins == 0 {
+			dropped = append(dropped, s)
+		}
+	}
+	c.pinMu.Unlock()
+	for _, s := range dropped {
+		c.drop(s)
+	}
+}
+
+func (c *Collection) drop(s *segment) {
+	s.close()
+	if s.remove {
+		removeSegment(c.backend, c.name, s.id)
+	}
+}
+
+// Delete marks the record deleted, it is excluded from search and dropped by segments merge
+func (c *Collection) Delete(n int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seg, err := c.segmentOf(n)
+	if err != nil {
+		return err
+	}
+	if seg.deleted[n-seg.base] {
+		return nil
+	}
+	seg.deleted[n-seg.base] = true
+	if err := seg.saveDeleted(c.backend, c.name); err != nil {
+		delete(seg.deleted, n-seg.base)
+		return err
+	}
+	return nil
+}
+
+func (c *Collection) Index(n int) (*IndexRecord, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	seg, err := c.segmentOf(n)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := seg.record(n - seg.base)
+	if err != nil {
+		return nil, err
 	}
-	ret.Vector = bytesToFloat32Slice(c.index[start:end])
-	return &ret, nil
+	ret.Position += seg.dataBase
+	return ret, nil
 }
 
+// Data reads the data at the position, Position and Size of the index record or search result return
+// the payload of the record without its fields and vector sections. Other ranges are read as stored.
 func (c *Collection) Data(pos, size int) ([]byte, error) {
-	if pos < 0 || size <= 0 || size >= c.dataStorage.size() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s := c.dataSegment(pos)
+	if s == nil {
 		return nil, ErrDataPosition
 	}
-	reader, err := c.dataStorage.reader(pos)
+	data, err := s.data(pos-s.dataBase, size)
 	if err != nil {
 		return nil, err
 	}
-	out := make([]byte, size)
-	cnt, err := reader.Read(out)
+	n := s.recordAt(pos-s.dataBase, size)
+	if n < 0 || s.flags(n) == 0 {
+		return data, nil
+	}
+	d, err := decodeData(data, s.flags(n), c.dictionaries)
 	if err != nil {
 		return nil, err
 	}
-	if cnt != size {
-		return nil, ErrReadData
+	return d.data, nil
+}
+
+// Get returns the record n with its data, the data is located by the index
+func (c *Collection) Get(n int) (Record, error) {
+	recs, err := c.GetMany([]int{n})
+	if err != nil {
+		return Record{}, err
 	}
-	return out, nil
+	return recs[0], nil
 }
 
-func (c *Collection) Close() error {
+// GetMany returns records with numbers ns in the same order, data of the records is read in position order.
+// Error is returned if any of the records is deleted or does not exist.
+func (c *Collection) GetMany(ns []int) ([]Record, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]Record, len(ns))
+	refs := make([][2]int, len(ns))
+	for i, n := range ns {
+		seg, err := c.segmentOf(n)
+		if err != nil {
+			return nil, fmt.Errorf("%w: record %d", err, n)
+		}
+		if seg.deleted[n-seg.base] {
+			return nil, fmt.Errorf("%w: record %d", ErrDeleted, n)
+		}
+		pos, size := seg.entry(n - seg.base)
+		if pos < 0 || size < 0 || pos+size > seg.dataSize {
+			return nil, fmt.Errorf("%w: data of record %d is out of segment", ErrCorruptedDb, n)
+		}
+		out[i] = Record{N: n, Vector: seg.vector(n - seg.base)}
+		refs[i] = [2]int{seg.dataBase + pos, size}
+		refs[i][1] |= seg.flags(n - seg.base)
+	}
 	var errs []error
-	if err := c.indexStorage.closeReader(); err != nil {
-		errs = append(errs, err)
+	err := c.readSorted(len(ns), func(i int) (int, int) {
+		return refs[i][0], refs[i][1] & sizeMask
+	}, func(i int, data []byte) {
+		errs = append(errs, decodeRecord(&out[i], data, refs[i][1]&^sizeMask, c.dictionaries, c.encoding, c.vectorSize))
+	})
+	if err != nil {
+		return nil, err
 	}
-	if err := c.indexStorage.closeWriter(); err != nil {
-		errs = append(errs, err)
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
 	}
-	if err := c.dataStorage.closeReader(); err != nil {
-		errs = append(errs, err)
+	return out, nil
+}
+
+// dataSegment returns the segment containing data position, nil if there is no such segment
+func (c *Collection) dataSegment(pos int) *segment {
+	for _, s := range c.segments {
+		if pos >= s.dataBase && pos < s.dataBase+s.dataSize {
+			return s
+		}
 	}
-	if err := c.dataStorage.closeWriter(); err != nil {
-		errs = append(errs, err)
+	return nil
+}
+
+func (c *Collection) Close() error {
+	c.stopBackgroundMerge()
+	c.stopFollower()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.release != nil {
+		c.release()
+	}
+	var errs []error
+	for _, s := range c.segments {
+		if err := s.close(); err != nil {
+			errs = append(errs, err)
+		}
 	}
 	if len(errs) > 0 {
 		return errors.Join(errs...)
@@ -1,7 +1,11 @@
 package vech
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -120,3 +124,163 @@ func TestCollectionFileSystem(t *testing.T) {
 		}
 	}
 }
+
+func TestCollectionConcurrency(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []CreateDbOptions{
+		{VectorSize: 4, StorageType: Memory},
+		{VectorSize: 4, StorageType: FileSystem, Path: path, SegmentSize: 1024},
+	} {
+		db, err := CreateDb(&opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := db.OpenCollection("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := randomChunks(500, 4)
+		if err = addChunks(c, data[:10]); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan struct{})
+		errs := make(chan error, 8)
+		var wg sync.WaitGroup
+		for r := 0; r < 8; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					dist, err := c.CosineSim(data[0].vector, SortDesc, 5)
+					if err != nil {
+						errs <- err
+						return
+					}
+					for _, d := range dist {
+						got, err := c.Data(d.Position, d.Size)
+						if err != nil {
+							errs <- err
+							return
+						}
+						if !reflect.DeepEqual(data[d.N].data, got) {
+							errs <- fmt.Errorf("data %d read %v does not match to original: %v", d.N, got, data[d.N].data)
+							return
+						}
+					}
+					n := c.Len() - 1
+					rec, err := c.Index(n)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if !reflect.DeepEqual(data[n].vector, rec.Vector) {
+						errs <- fmt.Errorf("vector %d read %v does not match to original: %v", n, rec.Vector, data[n].vector)
+						return
+					}
+				}
+			}()
+		}
+		err = addChunks(c, data[10:])
+		close(done)
+		wg.Wait()
+		close(errs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for err := range errs {
+			t.Fatal(err)
+		}
+		checkChunks(t, c, data)
+		c.Close()
+	}
+}
+
+func TestCollectionAddMany(t *testing.T) {
+	c := newMemoryCollection(t, 4)
+	vectors := make([][]float32, len(chunks))
+	data := make([][]byte, len(chunks))
+	for i, d := range chunks {
+		vectors[i], data[i] = d.vector, d.data
+	}
+	n, err := c.AddMany(context.Background(), vectors, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(chunks) {
+		t.Fatalf("expected %d added records, actual: %d", len(chunks), n)
+	}
+	checkChunks(t, c, chunks)
+
+	if _, err = c.AddMany(context.Background(), vectors, data[:1]); !errors.Is(err, ErrBatchSize) {
+		t.Fatalf("error expected to be ErrBatchSize, returned: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err = c.AddMany(ctx, vectors, nil)
+	if n != 0 || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected no records added and context.Canceled, actual: %d, %v", n, err)
+	}
+	if c.Len() != len(chunks) {
+		t.Fatalf("collection length expected to be %d, actual: %d", len(chunks), c.Len())
+	}
+}
+
+func TestCollectionGet(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path, SegmentSize: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// single record collection
+	if err = c.Add(chunks[0].vector, chunks[0].data); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := c.Get(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rec.Vector, chunks[0].vector) || !reflect.DeepEqual(rec.Data, chunks[0].data) {
+		t.Fatalf("record %v %v does not match to original: %v %v", rec.Vector, rec.Data, chunks[0].vector, chunks[0].data)
+	}
+
+	data := append([]testdata{chunks[0]}, randomChunks(40, 4)...)
+	data[5].data = nil
+	if err = addChunks(c, data[1:]); err != nil {
+		t.Fatal(err)
+	}
+	ns := []int{30, 2, 5, 17, 2, 0, 40}
+	recs, err := c.GetMany(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range ns {
+		if recs[i].N != n || !reflect.DeepEqual(recs[i].Vector, data[n].vector) || !reflect.DeepEqual(recs[i].Data, data[n].data) {
+			t.Fatalf("record %d %v does not match to original: %v", n, recs[i], data[n])
+		}
+	}
+	if err = c.Delete(17); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetMany(ns); !errors.Is(err, ErrDeleted) {
+		t.Fatalf("error expected to be ErrDeleted, returned: %v", err)
+	}
+	if _, err = c.Get(len(data)); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("error expected to be ErrIndexOutOfRange, returned: %v", err)
+	}
+}
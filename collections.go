@@ -0,0 +1,392 @@
+package vech
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrCollectionName     = errors.New("invalid collection name")
+	ErrCollectionExists   = errors.New("collection already exists")
+	ErrCollectionNotFound = errors.New("collection does not exist")
+	ErrCollectionOpen     = errors.New("collection is open")
+	ErrCollectionOptions  = errors.New("invalid collection options")
+)
+
+// maxCollectionName is the maximum length of collection name
+const maxCollectionName = 128
+
+// CollectionOptions are settings of the collection kept in its manifest
+type CollectionOptions struct {
+	VectorSize int           // vector dimensions, 0 means the vector size of the database
+	Metric     Metric        // vector comparison function
+	Encoding   Encoding      // format of stored vectors
+	IndexType  IndexType     // ANN index built for sealed segments
+	IVFLists   int           // amount of IVF clusters per segment, 0 means square root of segment length
+	IVFProbes  int           // amount of IVF clusters scanned by search, 0 means quarter of clusters
+	TextField  string        // record field indexed for text search, empty disables the text index
+	Vectors    []NamedVector // named vectors the records may hold besides the record vector
+}
+
+// validate checks the options and resolves database defaults
+func (opt CollectionOptions) validate(cfg *config) (*CollectionOptions, error) {
+	if opt.VectorSize == 0 {
+		opt.VectorSize = cfg.VectorSize
+	}
+	if opt.VectorSize <= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrVectorSize, opt.VectorSize)
+	}
+	if !opt.Metric.valid() || !opt.Encoding.valid() || opt.IndexType != FlatIndex && opt.IndexType != IVFIndex ||
+		opt.IVFLists < 0 || opt.IVFProbes < 0 {
+		return nil, fmt.Errorf("%w: %+v", ErrCollectionOptions, opt)
+	}
+	for i, v := range opt.Vectors {
+		if v.Name == "" || v.Size <= 0 || !v.Metric.valid() ||
+			slices.ContainsFunc(opt.Vectors[:i], func(prev NamedVector) bool { return prev.Name == v.Name }) {
+			return nil, fmt.Errorf("%w: named vector %+v", ErrCollectionOptions, v)
+		}
+	}
+	opt.Vectors = slices.Clone(opt.Vectors)
+	return &opt, nil
+}
+
+// Options returns settings of the collection
+func (c *Collection) Options() CollectionOptions {
+	return *c.options()
+}
+
+func (c *Collection) options() *CollectionOptions {
+	return &CollectionOptions{
+		VectorSize: c.vectorSize,
+		Metric:     c.metric,
+		Encoding:   c.encoding,
+		IndexType:  c.indexType,
+		IVFLists:   c.ivfLists,
+		IVFProbes:  c.ivfProbes,
+		TextField:  c.textField,
+		Vectors:    slices.Clone(c.named),
+	}
+}
+
+// CollectionStats describes the collection storage
+type CollectionStats struct {
+	Records   int // live records
+	Deleted   int // deleted records not removed by merge yet
+	Segments  int
+	IndexSize int // size of index storages in bytes
+	DataSize  int // size of data storages in bytes
+}
+
+// validateName checks the name of new collection consists of ASCII letters, digits, '_' and '-'.
+// Dots are reserved by segment file names, so names never contain path separators.
+func validateName(name string) error {
+	if name == "" || len(name) > maxCollectionName {
+		return fmt.Errorf("%w: %q", ErrCollectionName, name)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return fmt.Errorf("%w: %q", ErrCollectionName, name)
+		}
+	}
+	return nil
+}
+
+// checkName checks the name of existing collection does not leave the database directory.
+// Collections opened before the names were validated may have any other names, e.g. with dots.
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("%w: %q", ErrCollectionName, name)
+	}
+	return nil
+}
+
+// ListCollections returns sorted names of the database collections
+func (db *Db) ListCollections() ([]string, error) {
+	f, err := db.listFiles()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, file := range f.files {
+		name, ok, err := f.collection(file)
+		if err != nil {
+			return nil, err
+		}
+		if ok && !slices.Contains(out, name) {
+			out = append(out, name)
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// databaseFiles resolves the collections of database files. The file named like the segment of
+// the collection belongs to it only if the manifest of the collection lists the segment, otherwise
+// it belongs to the collection with dotted name.
+type databaseFiles struct {
+	backend  backend
+	files    []string
+	exists   map[string]bool
+	segments map[string][]int // segments listed by manifests read so far
+}
+
+func (db *Db) listFiles() (*databaseFiles, error) {
+	files, err := db.backend.list()
+	if err != nil {
+		return nil, err
+	}
+	f := databaseFiles{backend: db.backend, files: files, exists: make(map[string]bool, len(files)), segments: make(map[string][]int)}
+	for _, file := range files {
+		f.exists[file] = true
+	}
+	return &f, nil
+}
+
+// segmentsOf returns the segments listed by the manifest of the collection, collection without manifest
+// has the single segment
+func (f *databaseFiles) segmentsOf(name string) ([]int, error) {
+	if ids, ok := f.segments[name]; ok {
+		return ids, nil
+	}
+	ids := []int{0}
+	if f.exists[name+".manifest"] {
+		m, err := readManifest(f.backend, name)
+		if err != nil {
+			return nil, err
+		}
+		ids = m.Segments
+	}
+	f.segments[name] = ids
+	return ids, nil
+}
+
+// isSegment reports whether the name is the name of the segment listed by the manifest of other collection
+func (f *databaseFiles) isSegment(name string) (bool, error) {
+	i := strings.LastIndexByte(name, '.')
+	if i <= 0 {
+		return false, nil
+	}
+	id, err := strconv.Atoi(name[i+1:])
+	if err != nil || id <= 0 || strconv.Itoa(id) != name[i+1:] {
+		return false, nil
+	}
+	ids, err := f.segmentsOf(name[:i])
+	return slices.Contains(ids, id), err
+}
+
+// collection returns the collection of the database file, false if it is not collection file
+func (f *databaseFiles) collection(file string) (string, bool, error) {
+	name, ok := strings.CutSuffix(file, ".manifest")
+	if ok {
+		return name, checkName(name) == nil, nil
+	}
+	for _, ext := range segmentExts {
+		if name, ok = strings.CutSuffix(file, ext); ok {
+			break
+		}
+	}
+	if !ok {
+		return "", false, nil
+	}
+	segment, err := f.isSegment(name)
+	if err != nil {
+		return "", false, err
+	}
+	if segment {
+		name = name[:strings.LastIndexByte(name, '.')]
+	}
+	return name, checkName(name) == nil, nil
+}
+
+// of returns the files of the collection, nil if the collection does not exist
+func (f *databaseFiles) of(name string) ([]string, error) {
+	ids, err := f.segmentsOf(name)
+	if err != nil {
+		return nil, err
+	}
+	segment, err := f.isSegment(name)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, file := range f.files {
+		if !collectionFile(file, name, ids) {
+			continue
+		}
+		// the files of the first segment named like the segment of other collection belong to the latter
+		if segment && slices.Contains(segmentExts, file[len(name):]) {
+			continue
+		}
+		out = append(out, file)
+	}
+	return out, nil
+}
+
+// collectionFiles returns database files of the collection, nil if the collection does not exist
+func (db *Db) collectionFiles(name string) ([]string, error) {
+	f, err := db.listFiles()
+	if err != nil {
+		return nil, err
+	}
+	return f.of(name)
+}
+
+// CreateCollection creates new collection with the options, nil options mean the settings of the database.
+// It fails with ErrCollectionExists if the collection exists.
+func (db *Db) CreateCollection(name string, opt *CollectionOptions) (*Collection, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	if opt == nil {
+		opt = defaultOptions(db.config)
+	}
+	settings, err := opt.validate(db.config)
+	if err != nil {
+		return nil, err
+	}
+	db.admin.Lock()
+	defer db.admin.Unlock()
+	files, err := db.collectionFiles(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrCollectionExists, name)
+	}
+	if err := writeManifest(db.backend, name, &manifest{Segments: []int{0}, Options: settings}); err != nil {
+		return nil, err
+	}
+	return db.openCollection(name)
+}
+
+// OpenExistingCollection opens the collection, it fails with ErrCollectionNotFound if the collection does not exist
+func (db *Db) OpenExistingCollection(name string) (*Collection, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	db.admin.Lock()
+	defer db.admin.Unlock()
+	files, err := db.collectionFiles(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrCollectionNotFound, name)
+	}
+	return db.openCollection(name)
+}
+
+// checkClosed returns ErrCollectionOpen if the collection is opened by the instance
+func (db *Db) checkClosed(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.collections[name]; ok {
+		return fmt.Errorf("%w: %s", ErrCollectionOpen, name)
+	}
+	return nil
+}
+
+// DropCollection removes all files of the collection. The collection must be closed,
+// the instance can not detect the collection is used by other processes.
+func (db *Db) DropCollection(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	db.admin.Lock()
+	defer db.admin.Unlock()
+	if err := db.checkClosed(name); err != nil {
+		return err
+	}
+	files, err := db.collectionFiles(name)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("%w: %s", ErrCollectionNotFound, name)
+	}
+	for _, file := range files {
+		if err := db.backend.remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameCollection renames files of the closed collection, the manifest is renamed the last.
+// Files are renamed one by one, so the rename interrupted by crash has to be repeated manually.
+func (db *Db) RenameCollection(from, to string) error {
+	if err := errors.Join(checkName(from), validateName(to)); err != nil {
+		return err
+	}
+	db.admin.Lock()
+	defer db.admin.Unlock()
+	if err := errors.Join(db.checkClosed(from), db.checkClosed(to)); err != nil {
+		return err
+	}
+	files, err := db.collectionFiles(from)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("%w: %s", ErrCollectionNotFound, from)
+	}
+	existing, err := db.collectionFiles(to)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("%w: %s", ErrCollectionExists, to)
+	}
+	if i := slices.Index(files, from+".manifest"); i >= 0 {
+		files = append(slices.Delete(files, i, i+1), from+".manifest")
+	}
+	for _, file := range files {
+		if err := db.backend.rename(file, to+strings.TrimPrefix(file, from)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CollectionStats returns statistics of the collection, the closed collection is opened temporarily
+func (db *Db) CollectionStats(name string) (*CollectionStats, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	c, ok := db.collections[name]
+	db.mu.Unlock()
+	if ok {
+		return c.Stats(), nil
+	}
+	files, err := db.collectionFiles(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrCollectionNotFound, name)
+	}
+	c, err = openCollection(db.backend, name, db.config, db.recovers())
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.Stats(), nil
+}
+
+// Stats returns statistics of the collection
+func (c *Collection) Stats() *CollectionStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	st := CollectionStats{Segments: len(c.segments)}
+	for _, s := range c.segments {
+		st.Records += s.len() - len(s.deleted)
+		st.Deleted += len(s.deleted)
+		st.IndexSize += len(s.index)
+		st.DataSize += s.dataSize
+	}
+	return &st
+}
@@ -0,0 +1,352 @@
+package vech
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"foo", "Foo_1", "a-b"} {
+		if err := validateName(name); err != nil {
+			t.Fatalf("name %q expected to be valid, returned: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "../foo", "a/b", "a.b", ".", "a b", string(make([]byte, 200))} {
+		if err := validateName(name); !errors.Is(err, ErrCollectionName) {
+			t.Fatalf("name %q expected to be invalid, returned: %v", name, err)
+		}
+	}
+}
+
+func TestCollectionManagement(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileDb, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path, SegmentSize: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileDb.Close()
+	memDb, err := CreateDb(&CreateDbOptions{VectorSize: 4, StorageType: Memory, SegmentSize: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []*Db{fileDb, memDb} {
+		if _, err = db.OpenCollection("../foo"); !errors.Is(err, ErrCollectionName) {
+			t.Fatalf("error expected to be ErrCollectionName, returned: %v", err)
+		}
+		if _, err = db.OpenExistingCollection("foo"); !errors.Is(err, ErrCollectionNotFound) {
+			t.Fatalf("error expected to be ErrCollectionNotFound, returned: %v", err)
+		}
+		empty, err := db.CreateCollection("empty", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = empty.Close(); err != nil {
+			t.Fatal(err)
+		}
+		c, err := db.CreateCollection("foo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.CreateCollection("foo", nil); !errors.Is(err, ErrCollectionExists) {
+			t.Fatalf("error expected to be ErrCollectionExists, returned: %v", err)
+		}
+		data := randomChunks(20, 4)
+		if err = addChunks(c, data); err != nil {
+			t.Fatal(err)
+		}
+		if err = c.Delete(1); err != nil {
+			t.Fatal(err)
+		}
+		names, err := db.ListCollections()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, []string{"empty", "foo"}) {
+			t.Fatalf("unexpected collections: %v", names)
+		}
+		stats, err := db.CollectionStats("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Records != 19 || stats.Deleted != 1 || stats.Segments < 2 || stats.DataSize != 60 {
+			t.Fatalf("unexpected stats: %+v", stats)
+		}
+		if err = db.RenameCollection("foo", "bar"); !errors.Is(err, ErrCollectionOpen) {
+			t.Fatalf("error expected to be ErrCollectionOpen, returned: %v", err)
+		}
+		if err = db.DropCollection("foo"); !errors.Is(err, ErrCollectionOpen) {
+			t.Fatalf("error expected to be ErrCollectionOpen, returned: %v", err)
+		}
+		if err = c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		closedStats, err := db.CollectionStats("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *closedStats != *stats {
+			t.Fatalf("stats of closed collection %+v do not match to open one: %+v", closedStats, stats)
+		}
+
+		if err = db.RenameCollection("foo", "empty"); !errors.Is(err, ErrCollectionExists) {
+			t.Fatalf("error expected to be ErrCollectionExists, returned: %v", err)
+		}
+		if err = db.RenameCollection("foo", "bar"); err != nil {
+			t.Fatal(err)
+		}
+		if err = db.DropCollection("empty"); err != nil {
+			t.Fatal(err)
+		}
+		if err = db.DropCollection("empty"); !errors.Is(err, ErrCollectionNotFound) {
+			t.Fatalf("error expected to be ErrCollectionNotFound, returned: %v", err)
+		}
+		names, err = db.ListCollections()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, []string{"bar"}) {
+			t.Fatalf("unexpected collections: %v", names)
+		}
+		c, err = db.OpenExistingCollection("bar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.Get(1); !errors.Is(err, ErrDeleted) {
+			t.Fatalf("record 1 expected to stay deleted after rename, returned: %v", err)
+		}
+		rec, err := c.Get(19)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rec.Vector, data[19].vector) {
+			t.Fatalf("record vector %v does not match to original: %v", rec.Vector, data[19].vector)
+		}
+		if err = c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCollectionOptions(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path, SegmentSize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := []*CollectionOptions{{VectorSize: -1}, {Metric: 5}, {Encoding: 3}, {IndexType: 7}, {IVFLists: -1}}
+	for _, opt := range invalid {
+		if _, err = db.CreateCollection("foo", opt); err == nil {
+			t.Fatalf("options %+v expected to be invalid", opt)
+		}
+	}
+	settings := map[string]CollectionOptions{
+		"default": {VectorSize: 4},
+		"small":   {VectorSize: 3, Metric: Euclidean, Encoding: Float16, IndexType: IVFIndex, IVFLists: 2, IVFProbes: 2},
+		"large":   {VectorSize: 16, Metric: DotProduct},
+	}
+	vectors := make(map[string][]testdata)
+	for name, opt := range settings {
+		arg := &opt
+		if name == "default" {
+			arg = nil
+		}
+		c, err := db.CreateCollection(name, arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c.Options(), opt) {
+			t.Fatalf("collection %s options %+v do not match to requested: %+v", name, c.Options(), opt)
+		}
+		vectors[name] = randomChunks(100, opt.VectorSize)
+		if err = addChunks(c, vectors[name]); err != nil {
+			t.Fatal(err)
+		}
+		if err = c.Add(make([]float32, 5), nil); !errors.Is(err, ErrVectorSize) {
+			t.Fatalf("error expected to be ErrVectorSize, returned: %v", err)
+		}
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenFileDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for name, opt := range settings {
+		c, err := db.OpenExistingCollection(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c.Options(), opt) {
+			t.Fatalf("collection %s options %+v do not match to created: %+v", name, c.Options(), opt)
+		}
+		if c.Segments() < 2 {
+			t.Fatalf("collection %s expected to have sealed segments", name)
+		}
+		data := vectors[name]
+		res, err := c.Search(context.Background(), data[7].vector, &SearchOptions{Order: opt.Metric.closest(), Limit: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res[0].N != 7 && opt.Metric != DotProduct {
+			t.Fatalf("collection %s search expected to return record 7 first, actual: %v", name, res)
+		}
+		rec, err := c.Get(7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range rec.Vector {
+			if math.Abs(float64(v-data[7].vector[i])) > 1e-3 {
+				t.Fatalf("collection %s vector %v does not match to original: %v", name, rec.Vector, data[7].vector)
+			}
+		}
+		stats := c.Stats()
+		if stats.IndexSize != 100*(16+opt.VectorSize*opt.Encoding.size()) {
+			t.Fatalf("collection %s unexpected index size: %d", name, stats.IndexSize)
+		}
+	}
+	c, err := db.OpenExistingCollection("small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Search(context.Background(), []float32{0, 0, 0}, &SearchOptions{Order: SortAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(res); i++ {
+		if res[i].Value < res[i-1].Value || res[i].Value < 0 {
+			t.Fatalf("euclidean distances expected to be ascending: %v", res)
+		}
+	}
+}
+
+// renameFiles renames collection files on disk bypassing the name validation, like the collection
+// was created by the version accepting any names
+func renameFiles(t *testing.T, path, from, to string) {
+	t.Helper()
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if rest, ok := strings.CutPrefix(e.Name(), from+"."); ok {
+			if err = os.Rename(path+"/"+e.Name(), path+"/"+to+"."+rest); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestDottedCollectionName(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path, SegmentSize: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.CreateCollection("v1.2", nil); !errors.Is(err, ErrCollectionName) {
+		t.Fatalf("error expected to be ErrCollectionName, returned: %v", err)
+	}
+	// collections created before the validation: segmented v1.2, docs.en and foo.77 without manifest
+	data := randomChunks(20, 4)
+	for _, names := range [][2]string{{"v1_2", "v1.2"}, {"docs_en", "docs.en"}, {"foo_77", "foo.77"}, {"foo", ""}} {
+		c, err := db.OpenCollection(names[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks := data
+		if names[0] == "foo_77" {
+			chunks = data[:1]
+		}
+		if err = addChunks(c, chunks); err != nil {
+			t.Fatal(err)
+		}
+		if err = c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if names[1] != "" {
+			renameFiles(t, path, names[0], names[1])
+		}
+	}
+	if _, err = os.Stat(path + "/foo.1.idx"); err != nil {
+		t.Fatalf("collection foo expected to have segment 1: %v", err)
+	}
+	// new collection can not be named like the segment of other collection
+	for _, name := range []string{"foo.1", "foo.2", "docs.de"} {
+		if _, err = db.OpenCollection(name); !errors.Is(err, ErrCollectionName) {
+			t.Fatalf("new collection %q expected to be rejected, returned: %v", name, err)
+		}
+	}
+	foo, err := db.OpenExistingCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if foo.Len() != len(data) {
+		t.Fatalf("collection foo expected to keep %d records, actual: %d", len(data), foo.Len())
+	}
+	if err = foo.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names, err := db.ListCollections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"docs.en", "foo", "foo.77", "v1.2"}) {
+		t.Fatalf("unexpected collections: %v", names)
+	}
+	c, err := db.OpenExistingCollection("v1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, data)
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.CollectionStats("docs.en"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.RenameCollection("v1.2", "v1_2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.RenameCollection("docs.en", "docs.de"); !errors.Is(err, ErrCollectionName) {
+		t.Fatalf("error expected to be ErrCollectionName, returned: %v", err)
+	}
+	if err = db.DropCollection("docs.en"); err != nil {
+		t.Fatal(err)
+	}
+	// files of foo.77 are not segment files of foo
+	if err = db.DropCollection("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = db.OpenExistingCollection("foo.77"); err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, data[:1])
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if names, err = db.ListCollections(); err != nil || !reflect.DeepEqual(names, []string{"foo.77", "v1_2"}) {
+		t.Fatalf("unexpected collections: %v %v", names, err)
+	}
+	for _, name := range []string{"../foo", "a/b", ".."} {
+		if _, err = db.OpenExistingCollection(name); !errors.Is(err, ErrCollectionName) {
+			t.Fatalf("name %q expected to be rejected, returned: %v", name, err)
+		}
+	}
+}
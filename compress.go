@@ -0,0 +1,239 @@
+package vech
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+)
+
+// Compressed record data starts with uvarint number of the collection dictionary used by the compressor,
+// 0 means no dictionary, followed by DEFLATE stream. Data is stored uncompressed if compression does not
+// reduce its size. Dictionaries are kept in the collection manifest and never removed, so records
+// compressed with previous dictionaries remain readable.
+
+var ErrCodec = errors.New("unknown compression codec")
+
+// Codec is the compression codec of record data
+type Codec int
+
+const (
+	NoCompression Codec = iota
+	Flate
+)
+
+const (
+	minCompressSize = 64       // shorter data is stored uncompressed
+	maxDictSize     = 32 << 10 // DEFLATE window size
+	dictSampleStep  = 4        // step of substring sampling by dictionary training
+	dictSubstring   = 16       // length of sampled substrings
+)
+
+// compressor compresses record data by the collection settings, it is not safe for concurrent use
+type compressor struct {
+	level  int
+	dictID int // number of dictionary, 0 means no dictionary
+	dict   []byte
+	w      *flate.Writer
+	buf    bytes.Buffer
+}
+
+// newCompressor returns compressor with current settings, nil if compression is disabled, c.mu has to be held
+func (c *Collection) newCompressor() *compressor {
+	if c.codec == NoCompression {
+		return nil
+	}
+	cp := compressor{level: c.level, dictID: len(c.dictionaries)}
+	if cp.dictID > 0 {
+		cp.dict = c.dictionaries[cp.dictID-1]
+	}
+	return &cp
+}
+
+// compress returns compressed data with its flags, the data is returned as is if it is not compressed
+func (cp *compressor) compress(data []byte) ([]byte, int, error) {
+	if cp == nil || len(data) < minCompressSize {
+		return data, 0, nil
+	}
+	cp.buf.Reset()
+	cp.buf.Write(binary.AppendUvarint(nil, uint64(cp.dictID)))
+	if cp.w == nil {
+		w, err := flate.NewWriterDict(&cp.buf, cp.level, cp.dict)
+		if err != nil {
+			return nil, 0, err
+		}
+		cp.w = w
+	} else {
+		cp.w.Reset(&cp.buf)
+	}
+	if _, err := cp.w.Write(data); err != nil {
+		return nil, 0, err
+	}
+	if err := cp.w.Close(); err != nil {
+		return nil, 0, err
+	}
+	if cp.buf.Len() >= len(data) {
+		return data, 0, nil
+	}
+	return bytes.Clone(cp.buf.Bytes()), flateFlag, nil
+}
+
+// decompress restores compressed record data
+func decompress(blob []byte, dicts [][]byte) ([]byte, error) {
+	id, n := binary.Uvarint(blob)
+	if n <= 0 || id > uint64(len(dicts)) {
+		return nil, fmt.Errorf("%w: invalid compression dictionary", ErrCorruptedDb)
+	}
+	var dict []byte
+	if id > 0 {
+		dict = dicts[id-1]
+	}
+	r := flate.NewReaderDict(bytes.NewReader(blob[n:]), dict)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptedDb, err.Error())
+	}
+	return data, nil
+}
+
+// recordData is the stored data of the record split into its sections
+type recordData struct {
+	data   []byte            // payload
+	fields map[string][]byte // named fields
+	tokens []byte            // encoded token vectors
+	sparse []byte            // encoded sparse vector
+	named  []byte            // encoded named vectors
+}
+
+// decodeData splits the stored data of the record into the payload, fields and vector sections
+func decodeData(blob []byte, flags int, dicts [][]byte) (recordData, error) {
+	var d recordData
+	var err error
+	if flags&flateFlag != 0 {
+		if blob, err = decompress(blob, dicts); err != nil {
+			return d, err
+		}
+	}
+	if flags&multiFlag != 0 {
+		if d.tokens, blob, err = splitSection(blob); err != nil {
+			return d, err
+		}
+	}
+	if flags&sparseFlag != 0 {
+		if d.sparse, blob, err = splitSection(blob); err != nil {
+			return d, err
+		}
+	}
+	if flags&namedFlag != 0 {
+		if d.named, blob, err = splitSection(blob); err != nil {
+			return d, err
+		}
+	}
+	if flags&fieldsFlag == 0 {
+		d.data = blob
+		return d, nil
+	}
+	d.data, d.fields, err = decodeFields(blob)
+	return d, err
+}
+
+// decodeRecord fills the payload, fields, token, sparse and named vectors of the record from the stored data
+func decodeRecord(rec *Record, blob []byte, flags int, dicts [][]byte, enc Encoding, dims int) error {
+	d, err := decodeData(blob, flags, dicts)
+	if err != nil {
+		return err
+	}
+	rec.Data, rec.Fields = d.data, d.fields
+	rec.Vectors = enc.decodeVectors(d.tokens, dims)
+	if rec.Sparse, err = decodeSparse(d.sparse); err != nil {
+		return err
+	}
+	rec.Named, err = decodeNamed(d.named, enc)
+	return err
+}
+
+// SetCompression sets the codec and compression level of records added later, flate levels are valid.
+// Records stored before keep their encoding. Bulk writers keep the settings they were created with.
+func (c *Collection) SetCompression(codec Codec, level int) error {
+	if codec != NoCompression && codec != Flate {
+		return fmt.Errorf("%w: %d", ErrCodec, codec)
+	}
+	if _, err := flate.NewWriter(io.Discard, level); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prevCodec, prevLevel := c.codec, c.level
+	c.codec, c.level = codec, level
+	if err := writeManifest(c.backend, c.name, c.manifest()); err != nil {
+		c.codec, c.level = prevCodec, prevLevel
+		return err
+	}
+	c.comp = c.newCompressor()
+	return nil
+}
+
+// TrainDictionary builds compression dictionary of up to size bytes from substrings common to the samples.
+// Records added later are compressed with the dictionary. The size is limited by 32KB.
+func (c *Collection) TrainDictionary(samples [][]byte, size int) error {
+	dict := trainDictionary(samples, min(size, maxDictSize))
+	if len(dict) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dictionaries = append(c.dictionaries, dict)
+	if err := writeManifest(c.backend, c.name, c.manifest()); err != nil {
+		c.dictionaries = c.dictionaries[:len(c.dictionaries)-1]
+		return err
+	}
+	c.comp = c.newCompressor()
+	return nil
+}
+
+// trainDictionary selects substrings occurring in several samples, the most valuable are placed
+// at the end of the dictionary where DEFLATE references are the shortest
+func trainDictionary(samples [][]byte, size int) []byte {
+	counts := make(map[string]int)
+	for _, sample := range samples {
+		seen := make(map[string]bool)
+		for i := 0; i+dictSubstring <= len(sample); i += dictSampleStep {
+			sub := string(sample[i : i+dictSubstring])
+			if !seen[sub] {
+				seen[sub] = true
+				counts[sub]++
+			}
+		}
+	}
+	subs := make([]string, 0, len(counts))
+	for sub, n := range counts {
+		if n > 1 {
+			subs = append(subs, sub)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		if counts[subs[i]] != counts[subs[j]] {
+			return counts[subs[i]] > counts[subs[j]]
+		}
+		return subs[i] < subs[j]
+	})
+	var dict []byte
+	for _, sub := range subs {
+		if len(dict)+len(sub) > size {
+			break
+		}
+		dict = append(dict, sub...)
+	}
+	// the most frequent substrings go last
+	chunks := make([][]byte, 0, len(dict)/dictSubstring)
+	for i := 0; i < len(dict); i += dictSubstring {
+		chunks = append(chunks, dict[i:i+dictSubstring])
+	}
+	slices.Reverse(chunks)
+	return slices.Concat(chunks...)
+}
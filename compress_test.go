@@ -0,0 +1,145 @@
+package vech
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func textPayload(i int) []byte {
+	return []byte(fmt.Sprintf(`{"title": "document %d", "text": "the quick brown fox jumps over the lazy dog %d times", "source": "https://example.com/doc/%d"}`, i, i*7, i))
+}
+
+func TestCompression(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SetCompression(Codec(10), 0); !errors.Is(err, ErrCodec) {
+		t.Fatalf("error expected to be ErrCodec, returned: %v", err)
+	}
+	vectors := randomChunks(40, 4)
+	records := make([]Record, len(vectors))
+	for i := range records {
+		records[i] = Record{Vector: vectors[i].vector, Data: textPayload(i)}
+	}
+	records[1].Data = []byte{1, 2, 3}
+	records[2].Fields = map[string][]byte{"text": textPayload(100), "lang": []byte("en")}
+
+	add := func(from, to int) {
+		t.Helper()
+		for _, rec := range records[from:to] {
+			if err := c.AddRecord(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	add(0, 10)
+	if err = c.SetCompression(Flate, 9); err != nil {
+		t.Fatal(err)
+	}
+	add(10, 20)
+	samples := make([][]byte, 20)
+	for i := range samples {
+		samples[i] = textPayload(1000 + i)
+	}
+	if err = c.TrainDictionary(samples, 1024); err != nil {
+		t.Fatal(err)
+	}
+	add(20, 30)
+	w := c.BulkWriter(0)
+	for _, rec := range records[30:] {
+		if err = w.AddRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	size := func(n int) int {
+		t.Helper()
+		rec, err := c.Index(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec.Size
+	}
+	if size(5) != len(records[5].Data) {
+		t.Fatalf("record added before compression expected to be stored as is")
+	}
+	if size(15) >= len(records[15].Data) {
+		t.Fatalf("record expected to be compressed, stored size: %d, data size: %d", size(15), len(records[15].Data))
+	}
+	if size(25) >= size(15) {
+		t.Fatalf("dictionary expected to improve compression, stored size: %d, without dictionary: %d", size(25), size(15))
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenFileDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c, err = db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(2, 3)
+	records = append(records, records[2])
+	for n, expected := range records {
+		rec, err := c.Get(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rec.Data, expected.Data) || !reflect.DeepEqual(rec.Fields, expected.Fields) {
+			t.Fatalf("record %d %v does not match to original: %v", n, rec, expected)
+		}
+		if expected.Fields != nil {
+			continue
+		}
+		ix, err := c.Index(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := c.Data(ix.Position, ix.Size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(data, expected.Data) {
+			t.Fatalf("data of record %d %q does not match to original: %q", n, data, expected.Data)
+		}
+	}
+	res, err := c.CosineSim(records[15].Vector, SortDesc, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.Data(res[0].Position, res[0].Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].N != 15 || !reflect.DeepEqual(data, records[15].Data) {
+		t.Fatalf("data of search result %d %q does not match to original: %q", res[0].N, data, records[15].Data)
+	}
+	if size(len(records)-1) >= len(textPayload(100)) {
+		t.Fatalf("compression settings expected to be kept by reopened collection")
+	}
+	fields, err := c.GetFields(len(records)-1, "lang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fields, map[string][]byte{"lang": []byte("en")}) {
+		t.Fatalf("unexpected fields of compressed record: %v", fields)
+	}
+}
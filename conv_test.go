@@ -1,6 +1,10 @@
 package vech
 
-import "testing"
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
 
 func TestVectorConvert(t *testing.T) {
 	fs := []float32{0.11, 0.22, 0.33}
@@ -32,3 +36,36 @@ func TestIntConvert(t *testing.T) {
 		t.Fatalf("Expected %d, result %d", v, rs)
 	}
 }
+
+func TestFloat16Convert(t *testing.T) {
+	exact := []float32{0, 1, -2, 0.5, 65504, -65504, 1.0 / (1 << 24), float32(math.Inf(1)), float32(math.Inf(-1))}
+	for _, v := range exact {
+		if r := float16ToFloat32(float32ToFloat16(v)); r != v {
+			t.Fatalf("value %g expected to be converted exactly, actual: %g", v, r)
+		}
+	}
+	rounded := map[float32]float32{
+		1 + 1.0/(1<<11):     1,               // tie to even
+		1 + 3.0/(1<<11):     1 + 1.0/(1<<9),  // tie to even
+		1 + 1.0/(1<<10)*0.9: 1 + 1.0/(1<<10), // nearest
+		1e6:                 float32(math.Inf(1)),
+		1.0 / (1 << 26):     0,
+		3.0 / (1 << 25):     2.0 / (1 << 24),
+	}
+	for v, expected := range rounded {
+		if r := float16ToFloat32(float32ToFloat16(v)); r != expected {
+			t.Fatalf("value %g expected to be converted to %g, actual: %g", v, expected, r)
+		}
+	}
+	if r := float16ToFloat32(float32ToFloat16(float32(math.NaN()))); !math.IsNaN(float64(r)) {
+		t.Fatalf("NaN expected to be kept, actual: %g", r)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for range 1000 {
+		v := float32(rnd.NormFloat64())
+		r := float16ToFloat32(float32ToFloat16(v))
+		if math.Abs(float64(r-v)) > math.Abs(float64(v))/2048+1.0/(1<<25) {
+			t.Fatalf("value %g converted with too large error: %g", v, r)
+		}
+	}
+}
@@ -2,7 +2,10 @@ package vech
 
 import (
 	"errors"
+	"fmt"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
@@ -11,7 +14,11 @@ var (
 )
 
 type config struct {
-	VectorSize int
+	VectorSize  int
+	SegmentSize int
+	IndexType   IndexType
+	IVFLists    int
+	IVFProbes   int
 }
 
 // Db structrue is database instance
@@ -19,13 +26,39 @@ type Db struct {
 	path        string
 	config      *config
 	storageType StorageType
+	backend     backend
+	lock        *dbLock
+	follow      time.Duration // refresh period of opened collections, 0 disables following
+	keys        KeyProvider   // keys of encrypted database, nil if it is not encrypted
+	mu          sync.Mutex
+	collections map[string]*Collection // open collections
+	admin       sync.Mutex             // serializes creation, removal and renaming of collections
 }
 
 // CreateDbOptions are used for database creation
 type CreateDbOptions struct {
-	VectorSize  int
-	StorageType StorageType
-	Path        string
+	VectorSize    int
+	StorageType   StorageType
+	Path          string
+	SegmentSize   int           // active segment is sealed when it grows over the size in bytes, 0 disables segmentation
+	IndexType     IndexType     // ANN index built for sealed segments
+	IVFLists      int           // amount of IVF clusters per segment, 0 means square root of segment length
+	IVFProbes     int           // amount of IVF clusters scanned by search, 0 means quarter of clusters
+	Lock          LockMode      // inter-process lock of file database, exclusive by default, shared lock is not allowed
+	LockTimeout   time.Duration // time to wait for the lock, 0 fails immediately
+	EncryptionKey []byte        // AES key encrypting database files, 16, 24 or 32 bytes
+	Keys          KeyProvider   // provider of encryption keys, it takes precedence over EncryptionKey
+}
+
+// OpenDbOptions are used for opening file database
+type OpenDbOptions struct {
+	Path          string
+	Lock          LockMode      // inter-process lock of the database, see LockDefault, LockShared implies ReadOnly
+	LockTimeout   time.Duration // time to wait for the lock, 0 fails immediately
+	ReadOnly      bool          // database files are never created or modified, writes return ErrReadOnly
+	Follow        time.Duration // period of picking up changes of the writer process, see Collection.Refresh, it implies ReadOnly
+	EncryptionKey []byte        // AES key of encrypted database
+	Keys          KeyProvider   // provider of encryption keys, it takes precedence over EncryptionKey
 }
 
 // CreateDb creates new database
@@ -33,72 +66,203 @@ func CreateDb(opt *CreateDbOptions) (*Db, error) {
 	if opt.VectorSize < 0 {
 		return nil, ErrVectorSize
 	}
-	config := config{VectorSize: opt.VectorSize}
+	if opt.Lock == LockShared {
+		return nil, fmt.Errorf("%w: database is created with shared lock", ErrReadOnly)
+	}
+	config := config{
+		VectorSize:  opt.VectorSize,
+		SegmentSize: opt.SegmentSize,
+		IndexType:   opt.IndexType,
+		IVFLists:    opt.IVFLists,
+		IVFProbes:   opt.IVFProbes,
+	}
 	path := strings.TrimSuffix(opt.Path, "/")
-	db := Db{path: path, config: &config, storageType: opt.StorageType}
+	db := Db{path: path, config: &config, storageType: opt.StorageType, keys: keyProvider(opt.EncryptionKey, opt.Keys)}
 	switch opt.StorageType {
 	case FileSystem:
+		db.backend = &fsBackend{path: path}
+	case Memory:
+		db.backend = newMemBackend()
+	case S3:
+		return nil, fmt.Errorf("%w: S3 database is created by UploadFileDb", ErrReadOnly)
+	}
+	if db.keys != nil {
+		b, err := newEncBackend(db.backend, db.keys)
+		if err != nil {
+			return nil, err
+		}
+		db.backend = b
+	}
+	if opt.StorageType == FileSystem {
 		if err := checkOrCreateDir(path); err != nil {
 			return nil, err
 		}
-		if err := saveConfig(path+"/vech.cfg", &config); err != nil {
+		lock, err := acquireLock(path, lockMode(opt.Lock, false), opt.LockTimeout)
+		if err != nil {
+			return nil, err
+		}
+		db.lock = lock
+		if db.keys != nil {
+			err = writeConfigFile(db.backend, &config)
+		} else {
+			err = saveConfig(path+"/vech.cfg", &config)
+		}
+		if err != nil {
+			lock.release()
 			return nil, err
 		}
-	case Memory:
 	}
+	db.setRecovery()
 	return &db, nil
 }
 
-// OpenFileDb open file database
+// OpenFileDb opens file database for writing, the database is locked exclusively
 func OpenFileDb(path string) (*Db, error) {
-	path = strings.TrimSuffix(path, "/")
-	config, err := readConfig(path + "/vech.cfg")
-	if err != nil {
-		return nil, err
-	}
-	return &Db{path: path, config: config, storageType: FileSystem}, nil
+	return OpenDb(&OpenDbOptions{Path: path})
 }
 
-// OpenCollection opens collection if it exists, else it creates new collection
-func (db *Db) OpenCollection(name string) (*Collection, error) {
-	var id, dt storage
+// OpenDb opens file database with options
+func OpenDb(opt *OpenDbOptions) (*Db, error) {
+	path := strings.TrimSuffix(opt.Path, "/")
+	// followers and shared lock holders never modify files, the batch being written by the writer
+	// is not rolled back by them
+	readOnly := opt.ReadOnly || opt.Follow > 0 || opt.Lock == LockShared
+	var b backend = &fsBackend{path: path, readOnly: readOnly}
+	keys := keyProvider(opt.EncryptionKey, opt.Keys)
+	var config *config
 	var err error
-	switch db.storageType {
-	case FileSystem:
-		id, err = openFileStorage(db.path + "/" + name + ".idx")
-		if err != nil {
+	if keys != nil {
+		if b, err = newEncBackend(b, keys); err != nil {
 			return nil, err
 		}
-		dt, err = openFileStorage(db.path + "/" + name + ".data")
-		if err != nil {
-			return nil, err
+		config, err = readConfigFile(b)
+	} else {
+		config, err = readConfig(path + "/vech.cfg")
+	}
+	if err != nil {
+		return nil, err
+	}
+	lock, err := acquireLock(path, lockMode(opt.Lock, readOnly), opt.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	db := Db{
+		path:        path,
+		config:      config,
+		storageType: FileSystem,
+		backend:     b,
+		lock:        lock,
+		follow:      opt.Follow,
+		keys:        keys,
+	}
+	db.setRecovery()
+	return &db, nil
+}
+
+// Close closes collections opened by the instance and releases the database lock
+func (db *Db) Close() error {
+	var errs []error
+	for _, c := range db.openCollections() {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
 		}
-	case Memory:
-		id = newMemoryStorage()
-		dt = newMemoryStorage()
-	}
-	idxSize := id.size()
-	c := Collection{
-		indexStorage: id,
-		dataStorage:  dt,
-		vectorSize:   db.config.VectorSize,
-		recordSize:   db.config.VectorSize*4 + 16,
-		dataSize:     dt.size(),
-		index:        make([]byte, idxSize),
-	}
-	if idxSize > 0 {
-		reader, err := id.reader(0)
-		if err != nil {
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.lock.release(); err != nil {
+		errs = append(errs, err)
+	}
+	db.lock = nil
+	return errors.Join(errs...)
+}
+
+// OpenS3Db opens read only database published to S3 compatible object storage
+func OpenS3Db(opt *S3Options) (*Db, error) {
+	client, err := newS3Client(opt)
+	if err != nil {
+		return nil, err
+	}
+	var b backend = &s3Backend{client: client}
+	var config *config
+	if opt.Keys != nil {
+		if b, err = newEncBackend(b, opt.Keys); err != nil {
 			return nil, err
 		}
-		defer id.closeReader()
-		nread, err := reader.Read(c.index)
-		if err != nil {
+		config, err = readConfigFile(b)
+	} else {
+		config, err = readS3Config(client, "vech.cfg")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Db{path: client.prefix, config: config, storageType: S3, backend: b, keys: opt.Keys}, nil
+}
+
+// OpenCollection opens collection if it exists, else it creates new collection.
+// Names of new collections are validated like by CreateCollection.
+func (db *Db) OpenCollection(name string) (*Collection, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	db.admin.Lock()
+	defer db.admin.Unlock()
+	files, err := db.collectionFiles(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		if err := validateName(name); err != nil {
 			return nil, err
 		}
-		if nread != idxSize {
-			return nil, ErrCorruptedDb
+	}
+	return db.openCollection(name)
+}
+
+// recovers reports whether the instance rolls back writes interrupted by crash. Memory database has
+// no other writers, file database is recovered by the holder of the exclusive lock only.
+func (db *Db) recovers() bool {
+	return db.storageType == Memory || db.lock != nil && db.lock.mode == LockExclusive
+}
+
+// setRecovery enables rollback of encrypted storage appends interrupted by crash for the instance which recovers
+func (db *Db) setRecovery() {
+	if b, ok := db.backend.(*encBackend); ok {
+		b.recovers = db.recovers()
+	}
+}
+
+// openCollection opens the collection and registers it in the instance
+func (db *Db) openCollection(name string) (*Collection, error) {
+	c, err := openCollection(db.backend, name, db.config, db.recovers())
+	if err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.collections == nil {
+		db.collections = make(map[string]*Collection)
+	}
+	db.collections[name] = c
+	if db.follow > 0 {
+		c.startFollowing(db.follow)
+	}
+	c.release = func() {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if db.collections[name] == c {
+			delete(db.collections, name)
 		}
 	}
-	return &c, nil
+	return c, nil
+}
+
+// openCollections returns collections opened by the instance
+func (db *Db) openCollections() map[string]*Collection {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	out := make(map[string]*Collection, len(db.collections))
+	for name, c := range db.collections {
+		out[name] = c
+	}
+	return out
 }
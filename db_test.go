@@ -1,6 +1,9 @@
 package vech
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestCreateDb(t *testing.T) {
 	opt := CreateDbOptions{
@@ -36,14 +39,22 @@ func TestOpenFileDb(t *testing.T) {
 		StorageType: FileSystem,
 		Path:        path,
 	}
-	_, err = CreateDb(&opt)
+	created, err := CreateDb(&opt)
 	if err != nil {
 		t.Fatal(err)
 	}
+	// file database is exclusively locked by default
+	if _, err = OpenFileDb(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("error expected to be ErrLocked, returned: %v", err)
+	}
+	if err = created.Close(); err != nil {
+		t.Fatal(err)
+	}
 	db, err := OpenFileDb(path)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer db.Close()
 	if opt.VectorSize != db.config.VectorSize {
 		t.Fatal("config load error")
 	}
@@ -0,0 +1,100 @@
+package vech
+
+// diversified reports whether the results are re-ranked by MMR or group limit
+func (opt *SearchOptions) diversified() bool {
+	return opt.MMRLambda > 0 || opt.GroupField != "" && opt.MaxPerGroup > 0
+}
+
+// diversify selects up to opt.Limit results from candidates ordered by relevance. Every step selects
+// the candidate with the best MMR score, lambda*relevance - (1-lambda)*max similarity to the selected ones,
+// skipping candidates of the groups which reached the limit. Similarity of candidates is cosine similarity
+// of their vectors, relevance is cosine similarity too or the value normalized to [0, 1] for other metrics.
+// c.mu has to be held.
+func (c *Collection) diversify(res []Distance, opt *SearchOptions) ([]Distance, error) {
+	groups := make([]string, len(res))
+	grouped := make([]bool, len(res))
+	if opt.GroupField != "" && opt.MaxPerGroup > 0 {
+		var err error
+		if groups, grouped, err = c.fieldValues(res, opt.GroupField); err != nil {
+			return nil, err
+		}
+	}
+	var vectors [][]float32
+	relevance := normalizer(res, opt.Order)
+	if c.metric == Cosine && opt.Order == SortDesc {
+		// cosine similarity is on the scale of the similarity of candidates
+		relevance = func(v float32) float64 { return float64(v) }
+	}
+	lambda := float64(opt.MMRLambda)
+	if lambda > 0 {
+		vectors = make([][]float32, len(res))
+		for i, d := range res {
+			seg, err := c.segmentOf(d.N)
+			if err != nil {
+				return nil, err
+			}
+			vectors[i] = seg.vector(d.N - seg.base)
+		}
+	}
+	limit := opt.Limit
+	if limit <= 0 || limit > len(res) {
+		limit = len(res)
+	}
+	out := make([]Distance, 0, limit)
+	counts := make(map[string]int)
+	used := make([]bool, len(res))
+	maxSim := make([]float64, len(res)) // the largest similarity to selected results
+	for i := range maxSim {
+		maxSim[i] = -1
+	}
+	for len(out) < limit {
+		best, bestScore := -1, 0.0
+		for i := range res {
+			if used[i] || grouped[i] && counts[groups[i]] >= opt.MaxPerGroup {
+				continue
+			}
+			if lambda == 0 {
+				best = i // candidates are ordered by relevance
+				break
+			}
+			score := lambda*relevance(res[i].Value) - (1-lambda)*maxSim[i]
+			if best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+		used[best] = true
+		if grouped[best] {
+			counts[groups[best]]++
+		}
+		out = append(out, res[best])
+		if lambda > 0 {
+			for i := range res {
+				if !used[i] {
+					maxSim[i] = max(maxSim[i], float64(cosineSim(vectors[i], vectors[best])))
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// fieldValues reads the field of the results, the flags report whether the result has the field. c.mu has to be held.
+func (c *Collection) fieldValues(res []Distance, field string) ([]string, []bool, error) {
+	values, found := make([]string, len(res)), make([]bool, len(res))
+	for i, d := range res {
+		seg, err := c.segmentOf(d.N)
+		if err != nil {
+			return nil, nil, err
+		}
+		fields, err := c.readFields(seg, d.N-seg.base, []string{field})
+		if err != nil {
+			return nil, nil, err
+		}
+		value, ok := fields[field]
+		values[i], found[i] = string(value), ok
+	}
+	return values, found, nil
+}
@@ -0,0 +1,66 @@
+package vech
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSearchDiversified(t *testing.T) {
+	c := newMemoryCollection(t, 4)
+	// near duplicates of the query from document a, then documents b, c and d
+	vectors := [][]float32{
+		{1, 0, 0, 0}, {1, 0.01, 0, 0}, {1, 0, 0.01, 0}, {1, 0.01, 0.01, 0},
+		{0.7, 0.7, 0, 0}, {0.7, 0, 0.7, 0}, {0, 0, 0, 1},
+	}
+	docs := []string{"a", "a", "a", "a", "b", "c", "d"}
+	for i, v := range vectors {
+		rec := Record{Vector: v, Data: []byte{byte(i)}, Fields: map[string][]byte{"doc_id": []byte(docs[i])}}
+		if i == len(vectors)-1 {
+			rec.Fields = nil
+		}
+		if err := c.AddRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	query := []float32{1, 0, 0, 0}
+	numbers := func(opt *SearchOptions) string {
+		t.Helper()
+		res, err := c.Search(ctx, query, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ns := make([]int, len(res))
+		for i, r := range res {
+			ns[i] = r.N
+		}
+		return fmt.Sprint(ns)
+	}
+	if ns := numbers(&SearchOptions{Order: SortDesc, Limit: 3}); ns != "[0 1 2]" {
+		t.Fatalf("unexpected plain search results: %s", ns)
+	}
+	if ns := numbers(&SearchOptions{Order: SortDesc, Limit: 3, MMRLambda: 1}); ns != "[0 1 2]" {
+		t.Fatalf("MMR with lambda 1 expected to keep relevance order, results: %s", ns)
+	}
+	if ns := numbers(&SearchOptions{Order: SortDesc, Limit: 3, MMRLambda: 0.3, Candidates: 6}); ns != "[0 4 5]" {
+		t.Fatalf("unexpected MMR results: %s", ns)
+	}
+	if ns := numbers(&SearchOptions{Order: SortDesc, Limit: 4, GroupField: "doc_id", MaxPerGroup: 2}); ns != "[0 1 4 5]" {
+		t.Fatalf("unexpected results limited per group: %s", ns)
+	}
+	if ns := numbers(&SearchOptions{Order: SortDesc, GroupField: "doc_id", MaxPerGroup: 1}); ns != "[0 4 5 6]" {
+		t.Fatalf("unexpected results limited per group: %s", ns)
+	}
+	res, err := c.Search(ctx, query, &SearchOptions{Order: SortDesc, Limit: 2, MMRLambda: 0.5, WithData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[1].Data[0] != byte(res[1].N) {
+		t.Fatalf("data of diversified result %d does not match: %v", res[1].N, res[1].Data)
+	}
+	if _, err = c.Search(ctx, query, &SearchOptions{MMRLambda: 2}); !errors.Is(err, ErrSearchOptions) {
+		t.Fatalf("error expected to be ErrSearchOptions, returned: %v", err)
+	}
+}
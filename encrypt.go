@@ -0,0 +1,646 @@
+package vech
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Encrypted files
+//
+// Every encrypted file starts with the header: magic "VECHENC1" and 8 bytes id of the key, the id is
+// the prefix of SHA-256 of the key. Metadata files hold the nonce followed by AES-GCM sealed content,
+// the header is used as additional data. The header of storages is followed by 16 random bytes id of the file.
+// Storages are divided into blocks of encBlockSize plain bytes, every block is sealed separately with its own
+// random nonce and the file id and block number as additional data, so blocks can not be moved to other
+// positions or files and any position is read by decrypting one or two blocks. Appending overwrites
+// the partial last block in place, the sealed size and the sealed partial block before the append are kept
+// in the journal file while the storage is written, so the append interrupted by crash is rolled back.
+
+var (
+	ErrEncrypted   = errors.New("database is encrypted")
+	ErrKeyNotFound = errors.New("encryption key is not found")
+	ErrDecrypt     = errors.New("decryption error")
+)
+
+const (
+	encMagic      = "VECHENC1"
+	encHeaderSize = len(encMagic) + encKeyIDSize
+	encKeyIDSize  = 8
+	encFileIDSize = 16
+	encStoreSize  = encHeaderSize + encFileIDSize // header of storages
+	encBlockSize  = 4096
+	encNonceSize  = 12
+	encOverhead   = encNonceSize + 16 // nonce and GCM tag
+	encSealedSize = encBlockSize + encOverhead
+	rotateSuffix  = ".rotate"
+	journalExt    = ".tail" // suffix of the journal of the storage being appended
+)
+
+// KeyProvider supplies AES keys of encrypted database.
+// The first key encrypts written files, the others are previous keys decrypting files written before rotation.
+type KeyProvider interface {
+	Keys() ([][]byte, error)
+}
+
+// StaticKeys is KeyProvider of the fixed list of keys
+type StaticKeys [][]byte
+
+func (k StaticKeys) Keys() ([][]byte, error) {
+	return k, nil
+}
+
+type keyID [encKeyIDSize]byte
+
+func newKeyID(key []byte) keyID {
+	sum := sha256.Sum256(append([]byte("vech key id "), key...))
+	var id keyID
+	copy(id[:], sum[:])
+	return id
+}
+
+// encBackend encrypts files of the inner backend
+type encBackend struct {
+	inner   backend
+	current keyID
+	aeads   map[keyID]cipher.AEAD
+	mu      sync.Mutex
+	locks   map[string]*sync.RWMutex // storages opened several times share the lock of the file
+	// recovers rolls back appends interrupted by crash when storages are opened, it is set for the holder
+	// of the exclusive lock, other instances hide the interrupted append since it may be still written
+	recovers bool
+}
+
+func newEncBackend(inner backend, kp KeyProvider) (*encBackend, error) {
+	keys, err := kp.Keys()
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: key provider returned no keys", ErrKeyNotFound)
+	}
+	b := encBackend{inner: inner, aeads: make(map[keyID]cipher.AEAD, len(keys)), locks: make(map[string]*sync.RWMutex)}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := newKeyID(key)
+		if i == 0 {
+			b.current = id
+		}
+		b.aeads[id] = aead
+	}
+	return &b, nil
+}
+
+func (b *encBackend) header(id keyID) []byte {
+	return append([]byte(encMagic), id[:]...)
+}
+
+// parseHeader returns the cipher of the key the file was encrypted with
+func (b *encBackend) parseHeader(header []byte, name string) (keyID, cipher.AEAD, error) {
+	var id keyID
+	if len(header) < encHeaderSize || string(header[:len(encMagic)]) != encMagic {
+		return id, nil, fmt.Errorf("%w: %s is not encrypted", ErrDecrypt, name)
+	}
+	copy(id[:], header[len(encMagic):encHeaderSize])
+	aead, ok := b.aeads[id]
+	if !ok {
+		return id, nil, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	return id, aead, nil
+}
+
+func (b *encBackend) open(name string) (storage, error) {
+	st, err := b.inner.open(name)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	lock, ok := b.locks[name]
+	if !ok {
+		lock = &sync.RWMutex{}
+		b.locks[name] = lock
+	}
+	b.mu.Unlock()
+	// the append of the storage opened by the instance completes before its journal is read
+	lock.Lock()
+	defer lock.Unlock()
+	es := encStorage{inner: st, backend: b.inner, name: name, id: b.current, aead: b.aeads[b.current], mu: lock}
+	j, err := es.readJournal()
+	if err != nil {
+		return nil, err
+	}
+	if j != nil && b.recovers {
+		if err := es.restore(j); err != nil {
+			return nil, err
+		}
+	} else {
+		es.journal = j
+	}
+	if es.sealedSize() > 0 {
+		header := make([]byte, encStoreSize)
+		if _, err := st.readAt(header, 0); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if es.id, es.aead, err = b.parseHeader(header, name); err != nil {
+			return nil, err
+		}
+		copy(es.file[:], header[encHeaderSize:])
+	}
+	return &es, nil
+}
+
+func (b *encBackend) readFile(name string) ([]byte, error) {
+	data, err := b.inner.readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return b.decrypt(data, name)
+}
+
+func (b *encBackend) decrypt(data []byte, name string) ([]byte, error) {
+	_, aead, err := b.parseHeader(data, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < encHeaderSize+encOverhead {
+		return nil, fmt.Errorf("%w: %s is truncated", ErrDecrypt, name)
+	}
+	nonce := data[encHeaderSize : encHeaderSize+encNonceSize]
+	plain, err := aead.Open(nil, nonce, data[encHeaderSize+encNonceSize:], data[:encHeaderSize])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrDecrypt, name, err.Error())
+	}
+	return plain, nil
+}
+
+func (b *encBackend) encrypt(data []byte) ([]byte, error) {
+	out := b.header(b.current)
+	nonce := make([]byte, encNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return b.aeads[b.current].Seal(out, nonce, data, out[:encHeaderSize]), nil
+}
+
+func (b *encBackend) writeFile(name string, data []byte) error {
+	sealed, err := b.encrypt(data)
+	if err != nil {
+		return err
+	}
+	return b.inner.writeFile(name, sealed)
+}
+
+func (b *encBackend) remove(name string) error {
+	b.mu.Lock()
+	delete(b.locks, name)
+	b.mu.Unlock()
+	return b.inner.remove(name)
+}
+
+// rename keeps the content as is, the name is not bound to the ciphertext
+func (b *encBackend) rename(from, to string) error {
+	b.mu.Lock()
+	delete(b.locks, from)
+	delete(b.locks, to)
+	b.mu.Unlock()
+	return b.inner.rename(from, to)
+}
+
+func (b *encBackend) list() ([]string, error) {
+	return b.inner.list()
+}
+
+// encStorage is the storage of encrypted blocks, it is safe for concurrent readers and a writer
+type encStorage struct {
+	inner   storage
+	backend backend // inner backend keeping the journal
+	name    string
+	id      keyID
+	file    [encFileIDSize]byte // random id of the file bound to its blocks
+	aead    cipher.AEAD
+	mu      *sync.RWMutex // writes rewrite the last block, they exclude readers of the file
+	tail    []byte        // plain content of the partial last block, nil if it is not cached
+	journal *encJournal   // journal of the append hidden by the instance which does not recover, nil if there is none
+}
+
+// encJournal keeps the sealed block overwritten in place by append or truncate and the sealed size
+// the storage is restored to, the block is empty if no block is overwritten
+type encJournal struct {
+	Size   int
+	Offset int // position of the block in the sealed storage
+	Block  []byte
+}
+
+// plainSize converts the size of the sealed storage into plain size
+func plainSize(sealed int) int {
+	sealed -= encStoreSize
+	if sealed <= 0 {
+		return 0
+	}
+	return sealed/encSealedSize*encBlockSize + max(sealed%encSealedSize-encOverhead, 0)
+}
+
+func sealedOffset(block int) int {
+	return encStoreSize + block*encSealedSize
+}
+
+func (es *encStorage) size() int {
+	return plainSize(es.sealedSize())
+}
+
+// sealedSize returns the size of the sealed storage, the hidden append is excluded until its journal is removed
+func (es *encStorage) sealedSize() int {
+	if es.journal != nil {
+		if j, err := es.readJournal(); err != nil || j != nil {
+			return es.journal.Size
+		}
+		es.journal = nil
+	}
+	return es.inner.size()
+}
+
+// readJournal returns the journal of the append being written or interrupted by crash, nil if there is none
+func (es *encStorage) readJournal() (*encJournal, error) {
+	data, err := es.backend.readFile(es.name + journalExt)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var j encJournal
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&j); err != nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrCorruptedDb, es.name+journalExt, err.Error())
+	}
+	return &j, nil
+}
+
+// writeJournal keeps the sealed content at offset up to size in the journal before it is overwritten
+func (es *encStorage) writeJournal(offset, size int) (*encJournal, error) {
+	j := encJournal{Size: size, Offset: offset, Block: make([]byte, size-offset)}
+	if cnt, err := es.inner.readAt(j.Block, offset); cnt != len(j.Block) {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = ErrReadData
+		}
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(j); err != nil {
+		return nil, err
+	}
+	return &j, es.backend.writeFile(es.name+journalExt, buf.Bytes())
+}
+
+// restore rolls back the write of the journal: the overwritten block is written back, the storage
+// is truncated to the journal size and the journal is removed
+func (es *encStorage) restore(j *encJournal) error {
+	if len(j.Block) > 0 {
+		if err := es.inner.writeAt(j.Block, j.Offset); err != nil {
+			return err
+		}
+	}
+	if err := es.inner.truncate(j.Size); err != nil {
+		return err
+	}
+	es.tail = nil
+	return es.backend.remove(es.name + journalExt)
+}
+
+// block reads and decrypts the block, the last block may be partial
+func (es *encStorage) block(n int) ([]byte, error) {
+	buf := make([]byte, encSealedSize)
+	cnt, err := es.inner.readAt(buf, sealedOffset(n))
+	if cnt < encOverhead {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = ErrReadData
+		}
+		return nil, err
+	}
+	if j := es.journal; j != nil && len(j.Block) > 0 && sealedOffset(n) == j.Offset {
+		buf, cnt = j.Block, len(j.Block) // the block overwritten by the hidden append
+	}
+	plain, err := es.aead.Open(nil, buf[:encNonceSize], buf[encNonceSize:cnt], es.blockAD(n))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s block %d %s", ErrDecrypt, es.name, n, err.Error())
+	}
+	return plain, nil
+}
+
+// blockAD returns additional data of the block: the file id and the block number
+func (es *encStorage) blockAD(n int) []byte {
+	return binary.BigEndian.AppendUint64(es.file[:], uint64(n))
+}
+
+// seal encrypts plain blocks starting from block number first
+func (es *encStorage) seal(plain []byte, first int) ([]byte, error) {
+	out := make([]byte, 0, (len(plain)/encBlockSize+1)*encSealedSize)
+	for n := first; len(plain) > 0; n++ {
+		chunk := plain[:min(len(plain), encBlockSize)]
+		plain = plain[len(chunk):]
+		nonce := make([]byte, encNonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		out = append(out, nonce...)
+		out = es.aead.Seal(out, nonce, chunk, es.blockAD(n))
+	}
+	return out, nil
+}
+
+func (es *encStorage) writer() (io.Writer, error) {
+	if _, err := es.inner.writer(); err != nil {
+		return nil, err
+	}
+	return encWriter{es}, nil
+}
+
+type encWriter struct {
+	es *encStorage
+}
+
+// Write appends the data, the partial last block is replaced by the block containing appended data
+func (w encWriter) Write(p []byte) (int, error) {
+	es := w.es
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.append(p); err != nil {
+		es.tail = nil
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// append seals the partial last block with appended data and overwrites the block in place. The journal
+// keeps the overwritten block until the append is written, so the interrupted append is rolled back.
+func (es *encStorage) append(p []byte) error {
+	if es.journal != nil {
+		return fmt.Errorf("%w: %s", ErrNotRecovered, es.name)
+	}
+	sealed := es.inner.size()
+	if sealed == 0 {
+		if _, err := rand.Read(es.file[:]); err != nil {
+			return err
+		}
+	}
+	size := plainSize(sealed)
+	first := size / encBlockSize
+	if size%encBlockSize > 0 {
+		if es.tail == nil {
+			tail, err := es.block(first)
+			if err != nil {
+				return err
+			}
+			es.tail = tail
+		}
+		p = append(es.tail[:len(es.tail):len(es.tail)], p...)
+	}
+	out, err := es.seal(p, first)
+	if err != nil {
+		return err
+	}
+	position := sealedOffset(first)
+	if sealed == 0 {
+		out = append(append(append([]byte(encMagic), es.id[:]...), es.file[:]...), out...)
+		position = 0
+	}
+	j, err := es.writeJournal(min(position, sealed), sealed)
+	if err != nil {
+		return err
+	}
+	if err := es.inner.writeAt(out, position); err != nil {
+		// the journal is kept if restore fails, so the append is rolled back by the next open
+		return errors.Join(err, es.restore(j))
+	}
+	if err := es.backend.remove(es.name + journalExt); err != nil {
+		return err
+	}
+	es.tail = bytes.Clone(p[len(p)/encBlockSize*encBlockSize:])
+	return nil
+}
+
+func (es *encStorage) closeWriter() error {
+	return es.inner.closeWriter()
+}
+
+func (es *encStorage) reader(position int) (io.Reader, error) {
+	if position < 0 {
+		panic("negative file position")
+	}
+	size := es.size()
+	if position >= size {
+		return nil, fmt.Errorf("%w: position is greater than storage size", ErrSeek)
+	}
+	return io.NewSectionReader(storageReaderAt{es}, int64(position), int64(size-position)), nil
+}
+
+func (es *encStorage) closeReader() error {
+	return es.inner.closeReader()
+}
+
+func (es *encStorage) readAt(p []byte, position int) (int, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	size := es.size()
+	if position < 0 || position >= size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && position+n < size {
+		pos := position + n
+		plain, err := es.block(pos / encBlockSize)
+		if err != nil {
+			return n, err
+		}
+		off := pos % encBlockSize
+		if off >= len(plain) {
+			break
+		}
+		n += copy(p[n:], plain[off:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (es *encStorage) sync() error {
+	return es.inner.sync()
+}
+
+func (es *encStorage) truncate(size int) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.tail = nil
+	if size >= es.size() {
+		return nil
+	}
+	// the kept part of the last block is sealed in place before the rest is dropped, the journal restores
+	// the whole block if truncate is interrupted, so it is repeated by the recovery of the caller
+	block := size / encBlockSize
+	end := sealedOffset(block)
+	if size%encBlockSize == 0 {
+		return es.inner.truncate(end)
+	}
+	plain, err := es.block(block)
+	if err != nil {
+		return err
+	}
+	out, err := es.seal(plain[:size%encBlockSize], block)
+	if err != nil {
+		return err
+	}
+	j, err := es.writeJournal(end, min(end+encSealedSize, es.inner.size()))
+	if err != nil {
+		return err
+	}
+	if err := es.inner.writeAt(out, end); err != nil {
+		return errors.Join(err, es.restore(j))
+	}
+	if err := es.inner.truncate(end + len(out)); err != nil {
+		return errors.Join(err, es.restore(j))
+	}
+	return es.backend.remove(es.name + journalExt)
+}
+
+// writeAt is not supported, encrypted storages are only appended and truncated
+func (es *encStorage) writeAt(p []byte, position int) error {
+	return fmt.Errorf("%w: positional write of encrypted storage %s", errors.ErrUnsupported, es.name)
+}
+
+// RotateKeys re-encrypts all files of the file database at path which are not encrypted by the first key
+// of the provider. Files are replaced one by one, so the interrupted rotation is continued by the next call
+// as long as the provider keeps previous keys. The database must not be used by other processes.
+func RotateKeys(path string, kp KeyProvider) error {
+	path = strings.TrimSuffix(path, "/")
+	lock, err := acquireLock(path, LockExclusive, 0)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	raw := &fsBackend{path: path}
+	b, err := newEncBackend(raw, kp)
+	if err != nil {
+		return err
+	}
+	b.recovers = true
+	files, err := raw.list()
+	if err != nil {
+		return err
+	}
+	// appends interrupted by crash are rolled back by opening their storages, journals keep blocks
+	// sealed by the previous key
+	for _, name := range files {
+		if storage, ok := strings.CutSuffix(name, journalExt); ok {
+			st, err := b.open(storage)
+			if err != nil {
+				return err
+			}
+			if err := errors.Join(st.closeWriter(), st.closeReader()); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range files {
+		if strings.HasSuffix(name, journalExt) {
+			continue
+		}
+		if strings.HasSuffix(name, rotateSuffix) {
+			if err := raw.remove(name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := b.rotate(raw, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotate re-encrypts the file with the current key
+func (b *encBackend) rotate(raw *fsBackend, name string) error {
+	f, err := os.Open(raw.path + "/" + name)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, encHeaderSize)
+	_, err = io.ReadFull(f, header)
+	f.Close()
+	if errors.Is(err, io.EOF) {
+		return nil // empty storage
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s %s", ErrDecrypt, name, err.Error())
+	}
+	id, _, err := b.parseHeader(header, name)
+	if err != nil || id == b.current {
+		return err
+	}
+	if !isStorageFile(name) {
+		data, err := b.readFile(name)
+		if err != nil {
+			return err
+		}
+		return b.writeFile(name, data)
+	}
+	tmp := name + rotateSuffix
+	if err := raw.remove(tmp); err != nil {
+		return err
+	}
+	if err := copyStorage(b, name, -1, b, tmp); err != nil {
+		raw.remove(tmp)
+		return err
+	}
+	return os.Rename(raw.path+"/"+tmp, raw.path+"/"+name)
+}
+
+// keyProvider returns the provider of the single key or the given provider, nil if there are no keys
+func keyProvider(key []byte, kp KeyProvider) KeyProvider {
+	if kp == nil && key != nil {
+		return StaticKeys{key}
+	}
+	return kp
+}
+
+// readConfigFile reads the config through the backend, it is used for encrypted databases
+func readConfigFile(b backend) (*config, error) {
+	data, err := b.readFile("vech.cfg")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrConfigAbsent
+		}
+		return nil, err
+	}
+	var c config
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrReadConfig, err.Error())
+	}
+	return &c, nil
+}
+
+// writeConfigFile writes the config through the backend
+func writeConfigFile(b backend, c *config) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return fmt.Errorf("%w: %s", ErrWriteConfig, err.Error())
+	}
+	return b.writeFile("vech.cfg", buf.Bytes())
+}
@@ -0,0 +1,319 @@
+package vech
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncStorage(t *testing.T) {
+	b, err := newEncBackend(newMemBackend(), StaticKeys{bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := b.open("foo.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := st.writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	var plain []byte
+	write := func(n int) {
+		t.Helper()
+		p := make([]byte, n)
+		rnd.Read(p)
+		if _, err := w.Write(p); err != nil {
+			t.Fatal(err)
+		}
+		plain = append(plain, p...)
+	}
+	check := func() {
+		t.Helper()
+		if st.size() != len(plain) {
+			t.Fatalf("storage size expected to be %d, actual: %d", len(plain), st.size())
+		}
+		for _, pos := range []int{0, 1, encBlockSize - 3, encBlockSize, len(plain) / 2, len(plain) - 7} {
+			buf := make([]byte, min(5000, len(plain)-pos))
+			if _, err := st.readAt(buf, pos); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, plain[pos:pos+len(buf)]) {
+				t.Fatalf("data read at %d does not match to written", pos)
+			}
+		}
+		r, err := st.reader(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(all, plain) {
+			t.Fatalf("data read by reader does not match to written")
+		}
+	}
+	for _, n := range []int{10, 100, encBlockSize, 3 * encBlockSize / 2, 1} {
+		write(n)
+	}
+	check()
+	if _, err = st.readAt(make([]byte, 10), len(plain)-5); !errors.Is(err, io.EOF) {
+		t.Fatalf("error expected to be io.EOF, returned: %v", err)
+	}
+	for _, size := range []int{len(plain) - 3, 2*encBlockSize + 5, 2 * encBlockSize} {
+		if err = st.truncate(size); err != nil {
+			t.Fatal(err)
+		}
+		plain = plain[:size]
+		write(encBlockSize + 20)
+		check()
+	}
+
+	raw, err := b.inner.open("foo.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := make([]byte, raw.size())
+	if _, err = raw.readAt(sealed, 0); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, plain[:64]) {
+		t.Fatalf("storage expected to be encrypted")
+	}
+	sealed[sealedOffset(1)+encNonceSize] ^= 1
+	if err = raw.truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	rw, err := raw.writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rw.Write(sealed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = st.readAt(make([]byte, 10), encBlockSize); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("error expected to be ErrDecrypt, returned: %v", err)
+	}
+
+	// blocks of other files are not accepted even at the same position
+	other, err := b.open("bar.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ow, err := other.writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ow.Write(plain[:2*encBlockSize]); err != nil {
+		t.Fatal(err)
+	}
+	rawOther, err := b.inner.open("bar.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := make([]byte, encSealedSize)
+	if _, err = rawOther.readAt(block, sealedOffset(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err = raw.writeAt(block, sealedOffset(0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = st.readAt(make([]byte, 10), 0); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("spliced block expected to fail with ErrDecrypt, returned: %v", err)
+	}
+	buf := make([]byte, 10)
+	if _, err = other.readAt(buf, 0); err != nil || !bytes.Equal(buf, plain[:10]) {
+		t.Fatalf("content of other file does not match to written: %v", err)
+	}
+}
+
+func TestEncStorageTornAppend(t *testing.T) {
+	b, err := newEncBackend(newMemBackend(), StaticKeys{bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := b.open("foo.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := st.writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := bytes.Repeat([]byte{7}, 100)
+	if _, err = w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	// append interrupted by crash after the journal was written and the partial block was partly overwritten
+	es := st.(*encStorage)
+	if _, err = es.writeJournal(sealedOffset(0), es.inner.size()); err != nil {
+		t.Fatal(err)
+	}
+	if err = es.inner.writeAt(bytes.Repeat([]byte{1}, encSealedSize+50), sealedOffset(0)+10); err != nil {
+		t.Fatal(err)
+	}
+	check := func(st storage) {
+		t.Helper()
+		if st.size() != len(plain) {
+			t.Fatalf("storage size expected to be %d, actual: %d", len(plain), st.size())
+		}
+		buf := make([]byte, len(plain))
+		if _, err := st.readAt(buf, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, plain) {
+			t.Fatal("data read does not match to written")
+		}
+	}
+
+	// instance which does not recover hides the append and reads the overwritten block from the journal
+	hidden, err := b.open("foo.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(hidden)
+	if hw, err := hidden.writer(); err != nil {
+		t.Fatal(err)
+	} else if _, err = hw.Write(plain); !errors.Is(err, ErrNotRecovered) {
+		t.Fatalf("error expected to be ErrNotRecovered, returned: %v", err)
+	}
+	if _, err = b.inner.readFile("foo.data" + journalExt); err != nil {
+		t.Fatalf("journal expected to be kept, read returned: %v", err)
+	}
+
+	b.recovers = true
+	restored, err := b.open("foo.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(restored)
+	if _, err = b.inner.readFile("foo.data" + journalExt); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("journal expected to be removed, read returned: %v", err)
+	}
+	rw, err := restored.writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rw.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	plain = append(plain, plain...)
+	check(restored)
+	// the hidden append is visible again once the journal is removed
+	check(hidden)
+}
+
+func TestEncryptedDb(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := setupDir("testdb-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDir("testdb-snapshot")
+	key := bytes.Repeat([]byte{1}, 32)
+	opt := CreateDbOptions{VectorSize: 4, Path: path, SegmentSize: 8000, EncryptionKey: key}
+	db, err := CreateDb(&opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomChunks(500, 4)
+	for i := range data {
+		data[i].data = []byte("secret payload " + string(rune('a'+i%26)))
+	}
+	if err = addChunks(c, data); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.AddMany(context.Background(), [][]float32{data[0].vector, data[1].vector}, [][]byte{data[0].data, data[1].data}); err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, data[:2]...)
+	if err = db.Snapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		content, err := os.ReadFile(filepath.Join(path, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Name() != lockFile && len(content) > 0 && !bytes.HasPrefix(content, []byte(encMagic)) {
+			t.Fatalf("file %s expected to be encrypted", f.Name())
+		}
+		if bytes.Contains(content, []byte("secret payload")) {
+			t.Fatalf("file %s contains plain data", f.Name())
+		}
+	}
+
+	if _, err = OpenFileDb(path); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("error expected to be ErrEncrypted, returned: %v", err)
+	}
+	if _, err = OpenDb(&OpenDbOptions{Path: path, EncryptionKey: bytes.Repeat([]byte{2}, 32)}); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("error expected to be ErrKeyNotFound, returned: %v", err)
+	}
+	check := func(p string, keys KeyProvider) {
+		t.Helper()
+		db, err := OpenDb(&OpenDbOptions{Path: p, Keys: keys})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		c, err := db.OpenCollection("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n, d := range data {
+			rec, err := c.Get(n)
+			if n == 3 {
+				if !errors.Is(err, ErrDeleted) {
+					t.Fatalf("error expected to be ErrDeleted, returned: %v", err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(rec.Data, d.data) {
+				t.Fatalf("data %d read %q does not match to original: %q", n, rec.Data, d.data)
+			}
+		}
+	}
+	check(path, StaticKeys{key})
+	check(snap, StaticKeys{key})
+
+	newKey := bytes.Repeat([]byte{3}, 32)
+	if err = RotateKeys(path, StaticKeys{newKey}); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("error expected to be ErrKeyNotFound, returned: %v", err)
+	}
+	if err = RotateKeys(path, StaticKeys{newKey, key}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenDb(&OpenDbOptions{Path: path, EncryptionKey: key}); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("error expected to be ErrKeyNotFound, returned: %v", err)
+	}
+	check(path, StaticKeys{newKey})
+}
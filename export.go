@@ -0,0 +1,424 @@
+package vech
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+)
+
+// Archive format
+//
+// The archive is a stream of big endian values, strings and byte slices are prefixed by uvarint length.
+//
+//	header:
+//	  magic     8 bytes "VECHARC1"
+//	  flags     1 byte, bit 0: body is compressed with DEFLATE
+//	  dims      uint32, vector size
+//	  metric    string, metric of the collection: "cosine", "dot" or "euclidean"
+//	  encoding  string, encoding of the collection vectors: "float32" or "float16", archived values are float32
+//	body, compressed if the flag is set:
+//	  record:
+//	    tag       1 byte 'R'
+//	    seq       uvarint, sequence number of the record in the archive
+//	    vector    dims float32 values
+//	    payload   bytes
+//	    metadata  uvarint count of key-value pairs followed by key string and value bytes of every pair,
+//	              record fields are exported as metadata
+//	  multi-vector record:
+//	    tag       1 byte 'M'
+//	    record    fields of the record after the tag
+//	    tokens    uvarint count of token vectors followed by dims float32 values of every vector
+//	  record with sparse or named vectors:
+//	    tag       1 byte 'X'
+//	    record    fields of the multi-vector record after the tag, token vectors count may be 0
+//	    sparse    uvarint count of entries followed by uint32 index and float32 value of every entry
+//	    named     uvarint count of named vectors followed by name string, uint32 size and float32 values of every vector
+//	  trailer:
+//	    tag       1 byte 'E'
+//	    count     uvarint, amount of records in the archive
+//
+// Deleted records are not exported, so archive sequence numbers differ from collection record numbers.
+
+var (
+	ErrArchiveFormat = errors.New("archive format error")
+)
+
+const (
+	archiveMagic      = "VECHARC1"
+	archiveCompressed = 1
+	archiveRecord     = 'R'
+	archiveMulti      = 'M'
+	archiveSparse     = 'X'
+	archiveEnd        = 'E'
+	archiveMaxLength  = 1 << 30 // maximal length of payload, name or field value
+	archiveMaxCount   = 1 << 24 // maximal amount of fields, token vectors, sparse entries or named vectors
+	archiveChunk      = 1 << 16 // blobs up to the size are read into buffer of their length
+)
+
+// Export writes all live records of the collection into w in archive format
+func (c *Collection) Export(w io.Writer) error {
+	return c.export(w, false, 0)
+}
+
+// ExportCompressed writes the archive with DEFLATE compressed body, level is flate compression level
+func (c *Collection) ExportCompressed(w io.Writer, level int) error {
+	return c.export(w, true, level)
+}
+
+func (c *Collection) export(w io.Writer, compress bool, level int) error {
+	bw := bufio.NewWriter(w)
+	ar := archiveWriter{w: bw}
+	ar.bytes([]byte(archiveMagic))
+	var flags byte
+	if compress {
+		flags |= archiveCompressed
+	}
+	ar.bytes([]byte{flags})
+	ar.uint32(uint32(c.vectorSize))
+	ar.string(c.metric.name())
+	ar.string(c.encoding.name())
+	if ar.err != nil {
+		return ar.err
+	}
+	var fw *flate.Writer
+	if compress {
+		var err error
+		if fw, err = flate.NewWriter(bw, level); err != nil {
+			return err
+		}
+		ar.w = fw
+	}
+
+	seq := 0
+	var err error
+	c.records(0, -1, func(rec Record, rerr error) bool {
+		if rerr != nil {
+			err = rerr
+			return false
+		}
+		tag := byte(archiveRecord)
+		if rec.Sparse != nil || len(rec.Named) > 0 {
+			tag = archiveSparse
+		} else if len(rec.Vectors) > 0 {
+			tag = archiveMulti
+		}
+		ar.bytes([]byte{tag})
+		ar.uvarint(uint64(seq))
+		for _, v := range rec.Vector {
+			ar.uint32(math.Float32bits(v))
+		}
+		ar.blob(rec.Data)
+		ar.uvarint(uint64(len(rec.Fields)))
+		for _, name := range slices.Sorted(maps.Keys(rec.Fields)) {
+			ar.string(name)
+			ar.blob(rec.Fields[name])
+		}
+		if tag != archiveRecord {
+			ar.uvarint(uint64(len(rec.Vectors)))
+			for _, v := range rec.Vectors {
+				for _, x := range v {
+					ar.uint32(math.Float32bits(x))
+				}
+			}
+		}
+		if tag == archiveSparse {
+			var sparse SparseVector
+			if rec.Sparse != nil {
+				sparse = *rec.Sparse
+			}
+			ar.uvarint(uint64(len(sparse.Indices)))
+			for i, index := range sparse.Indices {
+				ar.uint32(index)
+				ar.uint32(math.Float32bits(sparse.Values[i]))
+			}
+			ar.uvarint(uint64(len(rec.Named)))
+			for _, name := range slices.Sorted(maps.Keys(rec.Named)) {
+				ar.string(name)
+				ar.uint32(uint32(len(rec.Named[name])))
+				for _, x := range rec.Named[name] {
+					ar.uint32(math.Float32bits(x))
+				}
+			}
+		}
+		if ar.err != nil {
+			err = ar.err
+			return false
+		}
+		seq++
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	ar.bytes([]byte{archiveEnd})
+	ar.uvarint(uint64(seq))
+	if ar.err != nil {
+		return ar.err
+	}
+	if fw != nil {
+		if err := fw.Close(); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Import appends all records of the archive to the collection
+func (c *Collection) Import(r io.Reader) error {
+	_, err := c.ImportFrom(r, 0)
+	return err
+}
+
+// ImportFrom appends the records of the archive skipping the first skip records.
+// It returns the amount of archive records processed including the skipped ones, so the value
+// can be used to resume interrupted import. Collection length can be used as well when the archive
+// was imported into empty collection.
+func (c *Collection) ImportFrom(r io.Reader, skip int) (int, error) {
+	br := bufio.NewReader(r)
+	ar := archiveReader{r: br}
+	magic := ar.fixed(len(archiveMagic))
+	if ar.err == nil && string(magic) != archiveMagic {
+		return 0, fmt.Errorf("%w: invalid magic", ErrArchiveFormat)
+	}
+	flags := ar.fixed(1)
+	dims := int(ar.uint32())
+	metric := ar.string()
+	encoding := ar.string()
+	if ar.err != nil {
+		return 0, ar.err
+	}
+	if dims != c.vectorSize {
+		return 0, fmt.Errorf("%w: collection vector size: %d, archive vector size: %d", ErrVectorSize, c.vectorSize, dims)
+	}
+	if metric != c.metric.name() || encoding != c.encoding.name() {
+		return 0, fmt.Errorf("%w: collection metric %s and encoding %s, archive metric %s and encoding %s",
+			ErrArchiveFormat, c.metric.name(), c.encoding.name(), metric, encoding)
+	}
+	if flags[0]&archiveCompressed != 0 {
+		fr := flate.NewReader(br)
+		defer fr.Close()
+		ar.r = bufio.NewReader(fr)
+	}
+
+	count := 0
+	vector := make([]float32, dims)
+	for {
+		tag := ar.fixed(1)
+		if ar.err != nil {
+			return count, ar.err
+		}
+		switch tag[0] {
+		case archiveEnd:
+			total := int(ar.uvarint())
+			if ar.err != nil {
+				return count, ar.err
+			}
+			if total != count {
+				return count, fmt.Errorf("%w: expected %d records, read: %d", ErrArchiveFormat, total, count)
+			}
+			return count, nil
+		case archiveRecord, archiveMulti, archiveSparse:
+		default:
+			return count, fmt.Errorf("%w: unexpected tag %d", ErrArchiveFormat, tag[0])
+		}
+		seq := int(ar.uvarint())
+		for i := range vector {
+			vector[i] = math.Float32frombits(ar.uint32())
+		}
+		payload := ar.blob()
+		var fields map[string][]byte
+		if meta := ar.count(); meta > 0 && ar.err == nil {
+			fields = make(map[string][]byte)
+			for range meta {
+				name := ar.string()
+				fields[name] = ar.blob()
+				if ar.err != nil {
+					break
+				}
+			}
+		}
+		var vectors [][]float32
+		if tag[0] != archiveRecord && ar.err == nil {
+			tokens := ar.count()
+			for range tokens {
+				v := make([]float32, dims)
+				for i := range v {
+					v[i] = math.Float32frombits(ar.uint32())
+				}
+				if ar.err != nil {
+					break
+				}
+				vectors = append(vectors, v)
+			}
+		}
+		var sparse *SparseVector
+		var named map[string][]float32
+		if tag[0] == archiveSparse && ar.err == nil {
+			if entries := ar.count(); entries > 0 {
+				sparse = &SparseVector{}
+				for range entries {
+					sparse.Indices = append(sparse.Indices, ar.uint32())
+					sparse.Values = append(sparse.Values, math.Float32frombits(ar.uint32()))
+					if ar.err != nil {
+						break
+					}
+				}
+			}
+			if n := ar.count(); n > 0 && ar.err == nil {
+				named = make(map[string][]float32)
+				for range n {
+					name := ar.string()
+					size := int(ar.uint32())
+					if ar.err != nil {
+						break
+					}
+					// the size is verified before the vector is allocated
+					decl, err := c.namedVector(name)
+					if err != nil || name == "" {
+						return count, fmt.Errorf("%w: %q", ErrVectorName, name)
+					}
+					if size != decl.Size {
+						return count, fmt.Errorf("%w: vector %s size: %d, archive vector size: %d", ErrVectorSize, name, decl.Size, size)
+					}
+					v := make([]float32, size)
+					for i := range v {
+						v[i] = math.Float32frombits(ar.uint32())
+					}
+					named[name] = v
+				}
+			}
+		}
+		if ar.err != nil {
+			return count, ar.err
+		}
+		if seq != count {
+			return count, fmt.Errorf("%w: expected record %d, read: %d", ErrArchiveFormat, count, seq)
+		}
+		if seq >= skip {
+			if err := c.AddRecord(Record{Vector: vector, Vectors: vectors, Sparse: sparse, Named: named, Data: payload, Fields: fields}); err != nil {
+				return count, err
+			}
+		}
+		count++
+	}
+}
+
+// archiveWriter keeps the first error, so the sequence of writes is checked once
+type archiveWriter struct {
+	w   io.Writer
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (aw *archiveWriter) bytes(p []byte) {
+	if aw.err == nil {
+		_, aw.err = aw.w.Write(p)
+	}
+}
+
+func (aw *archiveWriter) uint32(v uint32) {
+	binary.BigEndian.PutUint32(aw.buf[:], v)
+	aw.bytes(aw.buf[:4])
+}
+
+func (aw *archiveWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(aw.buf[:], v)
+	aw.bytes(aw.buf[:n])
+}
+
+func (aw *archiveWriter) blob(p []byte) {
+	aw.uvarint(uint64(len(p)))
+	aw.bytes(p)
+}
+
+func (aw *archiveWriter) string(s string) {
+	aw.blob([]byte(s))
+}
+
+// archiveReader keeps the first error, unexpected end of stream is reported as format error
+type archiveReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (ar *archiveReader) fail(err error) {
+	if ar.err != nil {
+		return
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("%w: unexpected end of archive", ErrArchiveFormat)
+	}
+	ar.err = err
+}
+
+func (ar *archiveReader) fixed(n int) []byte {
+	out := make([]byte, n)
+	if ar.err != nil {
+		return out
+	}
+	if _, err := io.ReadFull(ar.r, out); err != nil {
+		ar.fail(err)
+	}
+	return out
+}
+
+func (ar *archiveReader) uint32() uint32 {
+	return binary.BigEndian.Uint32(ar.fixed(4))
+}
+
+func (ar *archiveReader) uvarint() uint64 {
+	if ar.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(ar.r)
+	if err != nil {
+		ar.fail(err)
+	}
+	return v
+}
+
+// corrupted fails with the error of the length or count out of bounds
+func (ar *archiveReader) corrupted(what string, n uint64) {
+	ar.fail(fmt.Errorf("%w: %w: invalid %s %d", ErrArchiveFormat, ErrCorruptedDb, what, n))
+}
+
+// count reads uvarint amount of items bounded by archiveMaxCount
+func (ar *archiveReader) count() int {
+	n := ar.uvarint()
+	if n > archiveMaxCount {
+		ar.corrupted("count", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (ar *archiveReader) blob() []byte {
+	n := ar.uvarint()
+	if n > archiveMaxLength {
+		ar.corrupted("length", n)
+	}
+	if ar.err != nil {
+		return nil
+	}
+	if n <= archiveChunk {
+		return ar.fixed(int(n))
+	}
+	// long blob grows with the read data, so the length in truncated archive is not allocated upfront
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, ar.r, int64(n)); err != nil {
+		ar.fail(err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func (ar *archiveReader) string() string {
+	return string(ar.blob())
+}
@@ -0,0 +1,158 @@
+package vech
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"testing"
+)
+
+func newMemoryCollection(t *testing.T, vectorSize int) *Collection {
+	t.Helper()
+	db, err := CreateDb(&CreateDbOptions{VectorSize: vectorSize, StorageType: Memory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestExportImport(t *testing.T) {
+	src := newMemoryCollection(t, 4)
+	data := randomChunks(50, 4)
+	if err := addChunks(src, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Delete(10); err != nil {
+		t.Fatal(err)
+	}
+	data = append(data[:10], data[11:]...)
+
+	var plain, compressed bytes.Buffer
+	if err := src.Export(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.ExportCompressed(&compressed, flate.BestCompression); err != nil {
+		t.Fatal(err)
+	}
+	for _, archive := range []*bytes.Buffer{&plain, &compressed} {
+		dst := newMemoryCollection(t, 4)
+		if err := dst.Import(bytes.NewReader(archive.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		checkChunks(t, dst, data)
+	}
+
+	dst := newMemoryCollection(t, 8)
+	err := dst.Import(bytes.NewReader(plain.Bytes()))
+	if err == nil || !errors.Is(err, ErrVectorSize) {
+		t.Fatalf("error expected to be ErrVectorSize, returned: %v", err)
+	}
+	err = dst.Import(bytes.NewReader([]byte("not an archive")))
+	if err == nil || !errors.Is(err, ErrArchiveFormat) {
+		t.Fatalf("error expected to be ErrArchiveFormat, returned: %v", err)
+	}
+
+	// archives keep the metric and encoding of the collection
+	for _, opt := range []*CollectionOptions{{Metric: Euclidean}, {Encoding: Float16}} {
+		db, err := CreateDb(&CreateDbOptions{VectorSize: 4, StorageType: Memory})
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := db.CreateCollection("foo", opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var archive bytes.Buffer
+		if err = other.Export(&archive); err != nil {
+			t.Fatal(err)
+		}
+		if err = newMemoryCollection(t, 4).Import(&archive); !errors.Is(err, ErrArchiveFormat) {
+			t.Fatalf("archive of %+v expected to be rejected by cosine collection, returned: %v", *opt, err)
+		}
+	}
+}
+
+func TestImportResume(t *testing.T) {
+	src := newMemoryCollection(t, 4)
+	data := randomChunks(30, 4)
+	if err := addChunks(src, data); err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err := src.ExportCompressed(&archive, flate.DefaultCompression); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newMemoryCollection(t, 4)
+	n, err := dst.ImportFrom(bytes.NewReader(archive.Bytes()[:archive.Len()/2]), 0)
+	if err == nil || !errors.Is(err, ErrArchiveFormat) {
+		t.Fatalf("error expected to be ErrArchiveFormat, returned: %v", err)
+	}
+	if n == 0 || n != dst.Len() {
+		t.Fatalf("imported records expected to match collection length %d, actual: %d", dst.Len(), n)
+	}
+	n, err = dst.ImportFrom(bytes.NewReader(archive.Bytes()), dst.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) {
+		t.Fatalf("processed records expected to be %d, actual: %d", len(data), n)
+	}
+	checkChunks(t, dst, data)
+}
+
+func TestImportBounds(t *testing.T) {
+	// record header followed by the given tail
+	archive := func(tail func(aw *archiveWriter)) []byte {
+		var buf bytes.Buffer
+		aw := archiveWriter{w: &buf}
+		aw.bytes([]byte(archiveMagic))
+		aw.bytes([]byte{0})
+		aw.uint32(4)
+		aw.blob([]byte(Cosine.name()))
+		aw.blob([]byte(Float32.name()))
+		aw.bytes([]byte{archiveSparse})
+		aw.uvarint(0)
+		for range 4 {
+			aw.uint32(0)
+		}
+		tail(&aw)
+		return buf.Bytes()
+	}
+	c := newMemoryCollection(t, 4)
+	cases := map[string]func(aw *archiveWriter){
+		"payload length": func(aw *archiveWriter) { aw.uvarint(1 << 40) },
+		"fields count":   func(aw *archiveWriter) { aw.uvarint(0); aw.uvarint(1 << 40) },
+		"tokens count":   func(aw *archiveWriter) { aw.uvarint(0); aw.uvarint(0); aw.uvarint(1 << 30) },
+		"sparse count":   func(aw *archiveWriter) { aw.uvarint(0); aw.uvarint(0); aw.uvarint(0); aw.uvarint(1 << 40) },
+	}
+	for name, tail := range cases {
+		if _, err := c.ImportFrom(bytes.NewReader(archive(tail)), 0); !errors.Is(err, ErrCorruptedDb) {
+			t.Fatalf("%s: error expected to be ErrCorruptedDb, returned: %v", name, err)
+		}
+	}
+	// length in bounds of truncated archive fails by the end of data
+	truncated := archive(func(aw *archiveWriter) { aw.uvarint(1 << 29); aw.bytes([]byte{1, 2, 3}) })
+	if _, err := c.ImportFrom(bytes.NewReader(truncated), 0); !errors.Is(err, ErrArchiveFormat) {
+		t.Fatalf("error expected to be ErrArchiveFormat, returned: %v", err)
+	}
+	named := archive(func(aw *archiveWriter) {
+		aw.uvarint(0)
+		aw.uvarint(0)
+		aw.uvarint(0)
+		aw.uvarint(0)
+		aw.uvarint(1)
+		aw.blob([]byte("title"))
+		aw.uint32(1 << 30)
+	})
+	if _, err := c.ImportFrom(bytes.NewReader(named), 0); !errors.Is(err, ErrVectorName) {
+		t.Fatalf("error expected to be ErrVectorName, returned: %v", err)
+	}
+	if c.Len() != 0 {
+		t.Fatalf("no records expected to be imported, collection length: %d", c.Len())
+	}
+}
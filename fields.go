@@ -0,0 +1,275 @@
+package vech
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+)
+
+// Record fields
+//
+// The data of the record with fields starts with the field directory followed by field values:
+//
+//	count     uvarint, amount of fields
+//	field:    name string prefixed by uvarint length and uvarint value length of every field
+//	values    field values in directory order
+//
+// The record payload is stored as the field with empty name. Records added without fields keep
+// the payload as is, they are distinguished by the flag in the top byte of the index size value.
+
+var ErrFieldsFormat = errors.New("record fields format error")
+
+// fieldsPrefix is the amount of data read to decode field directory of the record at once
+const fieldsPrefix = 512
+
+// fieldRef locates field value in the record data
+type fieldRef struct {
+	name   string
+	offset int
+	size   int
+}
+
+// encodeFields encodes the payload and fields into record data
+func encodeFields(data []byte, fields map[string][]byte) ([]byte, error) {
+	all := maps.Clone(fields)
+	if all == nil {
+		all = make(map[string][]byte)
+	}
+	if _, ok := all[""]; ok {
+		return nil, fmt.Errorf("%w: empty field name", ErrFieldsFormat)
+	}
+	if len(data) > 0 {
+		all[""] = data
+	}
+	names := slices.Sorted(maps.Keys(all))
+	out := binary.AppendUvarint(nil, uint64(len(names)))
+	for _, name := range names {
+		out = binary.AppendUvarint(out, uint64(len(name)))
+		out = append(out, name...)
+		out = binary.AppendUvarint(out, uint64(len(all[name])))
+	}
+	for _, name := range names {
+		out = append(out, all[name]...)
+	}
+	return out, nil
+}
+
+// errShortDirectory means the directory does not fit into the provided prefix of record data
+var errShortDirectory = errors.New("short field directory")
+
+// decodeDirectory decodes field directory from the prefix of record data of size bytes
+func decodeDirectory(prefix []byte, size int) ([]fieldRef, error) {
+	p := prefix
+	next := func() (int, error) {
+		v, n := binary.Uvarint(p)
+		if n == 0 {
+			return 0, errShortDirectory
+		}
+		if n < 0 || v > math.MaxInt32 {
+			return 0, ErrFieldsFormat
+		}
+		p = p[n:]
+		return int(v), nil
+	}
+	count, err := next()
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]fieldRef, 0, min(count, len(prefix)))
+	for range count {
+		ln, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if len(p) < ln {
+			return nil, errShortDirectory
+		}
+		name := string(p[:ln])
+		p = p[ln:]
+		vl, err := next()
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, fieldRef{name: name, size: vl})
+	}
+	offset := len(prefix) - len(p)
+	for i := range refs {
+		refs[i].offset = offset
+		offset += refs[i].size
+	}
+	if offset != size {
+		return nil, fmt.Errorf("%w: fields size %d, record data size %d", ErrFieldsFormat, offset, size)
+	}
+	return refs, nil
+}
+
+// decodeFields splits record data into the payload and fields
+func decodeFields(blob []byte) ([]byte, map[string][]byte, error) {
+	refs, err := decodeDirectory(blob, len(blob))
+	if err != nil {
+		if errors.Is(err, errShortDirectory) {
+			err = ErrFieldsFormat
+		}
+		return nil, nil, err
+	}
+	var data []byte
+	fields := make(map[string][]byte, len(refs))
+	for _, r := range refs {
+		value := blob[r.offset : r.offset+r.size : r.offset+r.size]
+		if r.name == "" {
+			data = value
+		} else {
+			fields[r.name] = value
+		}
+	}
+	return data, fields, nil
+}
+
+// AddRecord adds the record with its vectors, payload and named fields, record number is ignored
+func (c *Collection) AddRecord(rec Record) error {
+	vector, blob, flags, err := c.encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.add(vector, blob, flags)
+}
+
+// encodeRecord returns the vector of the record and its data with the flags describing the encoding
+func (c *Collection) encodeRecord(rec Record) ([]float32, []byte, int, error) {
+	blob, flags := rec.Data, 0
+	if len(rec.Fields) > 0 {
+		var err error
+		if blob, err = encodeFields(rec.Data, rec.Fields); err != nil {
+			return nil, nil, 0, err
+		}
+		flags = fieldsFlag
+	}
+	vector := rec.Vector
+	var prefix []byte
+	if len(rec.Vectors) > 0 {
+		var err error
+		if vector, prefix, err = c.encodeTokens(rec.Vector, rec.Vectors); err != nil {
+			return nil, nil, 0, err
+		}
+		flags |= multiFlag
+	}
+	if rec.Sparse != nil && (len(rec.Sparse.Indices) > 0 || len(rec.Sparse.Values) > 0) {
+		sparse, err := encodeSparse(rec.Sparse)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		prefix = append(prefix, sparse...)
+		flags |= sparseFlag
+	}
+	if len(rec.Named) > 0 {
+		named, err := c.encodeNamed(rec.Named)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		prefix = append(prefix, named...)
+		flags |= namedFlag
+	}
+	if prefix == nil {
+		return vector, blob, flags, nil
+	}
+	return vector, append(prefix, blob...), flags, nil
+}
+
+// GetFields returns named fields of the record n, only requested fields are read.
+// All fields are returned if no names are given, absent fields are not included in the result.
+func (c *Collection) GetFields(n int, names ...string) (map[string][]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	seg, err := c.segmentOf(n)
+	if err != nil {
+		return nil, err
+	}
+	if seg.deleted[n-seg.base] {
+		return nil, ErrDeleted
+	}
+	return c.readFields(seg, n-seg.base, names)
+}
+
+// readFields reads the fields of the local segment record n, c.mu has to be held
+func (c *Collection) readFields(seg *segment, n int, names []string) (map[string][]byte, error) {
+	out := make(map[string][]byte)
+	flags := seg.flags(n)
+	if flags&fieldsFlag == 0 {
+		return out, nil
+	}
+	pos, size := seg.entry(n)
+	if flags&flateFlag != 0 {
+		// compressed fields are read at once
+		blob, err := seg.data(pos, size)
+		if err != nil {
+			return nil, err
+		}
+		d, err := decodeData(blob, flags, c.dictionaries)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range d.fields {
+			if len(names) == 0 || slices.Contains(names, name) {
+				out[name] = value
+			}
+		}
+		return out, nil
+	}
+	// vector sections precede the fields
+	for _, flag := range []int{multiFlag, sparseFlag, namedFlag} {
+		if flags&flag == 0 {
+			continue
+		}
+		skip, err := sectionSize(seg, pos, size)
+		if err != nil {
+			return nil, err
+		}
+		pos, size = pos+skip, size-skip
+	}
+	var refs []fieldRef
+	for ln := min(size, fieldsPrefix); ; ln = min(size, ln*4) {
+		prefix, err := seg.data(pos, ln)
+		if err != nil {
+			return nil, err
+		}
+		refs, err = decodeDirectory(prefix, size)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errShortDirectory) || ln == size {
+			if errors.Is(err, errShortDirectory) {
+				err = ErrFieldsFormat
+			}
+			return nil, err
+		}
+	}
+	refs = slices.DeleteFunc(refs, func(r fieldRef) bool {
+		return r.name == "" || len(names) > 0 && !slices.Contains(names, r.name)
+	})
+	for _, r := range refs {
+		out[r.name] = []byte{}
+	}
+	err := c.readSorted(len(refs), func(i int) (int, int) {
+		return seg.dataBase + pos + refs[i].offset, refs[i].size
+	}, func(i int, data []byte) {
+		out[refs[i].name] = data
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AddRecord buffers the record with its vectors, payload and named fields
+func (w *BulkWriter) AddRecord(rec Record) error {
+	vector, blob, flags, err := w.c.encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	return w.add(vector, blob, flags)
+}
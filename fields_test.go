@@ -0,0 +1,161 @@
+package vech
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeFields(t *testing.T) {
+	fields := map[string][]byte{"text": []byte("hello"), "json": []byte("{}"), "empty": {}}
+	blob, err := encodeFields([]byte{1, 2, 3}, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, decoded, err := decodeFields(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, []byte{1, 2, 3}) {
+		t.Fatalf("payload expected to be [1 2 3], actual: %v", data)
+	}
+	if !reflect.DeepEqual(decoded, fields) {
+		t.Fatalf("fields %v do not match to original: %v", decoded, fields)
+	}
+	if _, err = decodeDirectory(blob[:3], len(blob)); !errors.Is(err, errShortDirectory) {
+		t.Fatalf("error expected to be errShortDirectory, returned: %v", err)
+	}
+	if _, _, err = decodeFields(blob[:len(blob)-1]); !errors.Is(err, ErrFieldsFormat) {
+		t.Fatalf("error expected to be ErrFieldsFormat, returned: %v", err)
+	}
+	if _, err = encodeFields(nil, map[string][]byte{"": {1}}); !errors.Is(err, ErrFieldsFormat) {
+		t.Fatalf("error expected to be ErrFieldsFormat, returned: %v", err)
+	}
+}
+
+func TestCollectionFields(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path, SegmentSize: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("n", 1000)
+	records := make([]Record, 20)
+	for i, d := range randomChunks(len(records), 4) {
+		records[i] = Record{N: i, Vector: d.vector, Data: d.data}
+		switch i % 3 {
+		case 0:
+			records[i].Fields = map[string][]byte{"text": bytes.Repeat([]byte{'t'}, i), "source": {byte(i)}}
+		case 1:
+			records[i].Data = nil
+			records[i].Fields = map[string][]byte{"json": []byte("{}"), long: {2}}
+		}
+	}
+	w := c.BulkWriter(0)
+	for i, rec := range records {
+		if i%2 == 0 {
+			err = c.AddRecord(rec)
+		} else {
+			if err = w.AddRecord(rec); err == nil {
+				err = w.Flush()
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = c.Delete(5); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Merge(&MergePolicy{SegmentsPerTier: 2}); err != nil {
+		t.Fatal(err)
+	}
+	records = append(records[:5], records[6:]...)
+	for i := range records {
+		records[i].N = i
+	}
+
+	check := func(rec Record) {
+		t.Helper()
+		expected := records[rec.N]
+		if !reflect.DeepEqual(rec.Vector, expected.Vector) || !reflect.DeepEqual(rec.Data, expected.Data) ||
+			!reflect.DeepEqual(rec.Fields, expected.Fields) {
+			t.Fatalf("record %v does not match to original: %v", rec, expected)
+		}
+	}
+	for rec, err := range c.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(rec)
+	}
+	for n := range records {
+		rec, err := c.Get(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(rec)
+	}
+	res, err := c.Search(context.Background(), records[0].Vector, &SearchOptions{Order: SortDesc, WithData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res {
+		check(Record{N: r.N, Vector: records[r.N].Vector, Data: r.Data, Fields: r.Fields})
+		data, err := c.Data(r.Position, r.Size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, records[r.N].Data) {
+			t.Fatalf("data of record %d %q does not match to payload: %q", r.N, data, records[r.N].Data)
+		}
+	}
+
+	fields, err := c.GetFields(3, "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fields, map[string][]byte{"text": records[3].Fields["text"]}) {
+		t.Fatalf("unexpected fields: %v", fields)
+	}
+	fields, err = c.GetFields(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fields, records[4].Fields) {
+		t.Fatalf("fields %v do not match to original: %v", fields, records[4].Fields)
+	}
+	fields, err = c.GetFields(2, "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 0 {
+		t.Fatalf("record without fields expected to return no fields, actual: %v", fields)
+	}
+
+	var buf bytes.Buffer
+	if err = c.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := newMemoryCollection(t, 4)
+	if err = dst.Import(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for rec, err := range dst.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(rec)
+	}
+}
@@ -0,0 +1,98 @@
+package vech
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// Refresh picks up records, deletes and segments written by another process since the collection
+// was opened or refreshed. It is used by read-only followers of the database written by a single writer.
+// Record numbers change when the writer merges segments, so they are valid until the next refresh.
+func (c *Collection) Refresh() error {
+	m, err := readManifest(c.backend, c.name)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.codec, c.level, c.dictionaries = m.Codec, m.Level, m.Dictionaries
+	current := make(map[int]*segment, len(c.segments))
+	for _, s := range c.segments {
+		current[s.id] = s
+	}
+	segments := make([]*segment, 0, len(m.Segments))
+	var opened []*segment
+	fail := func(err error) error {
+		for _, s := range opened {
+			s.close()
+		}
+		return err
+	}
+	for i, id := range m.Segments {
+		s, ok := current[id]
+		if ok {
+			if err := s.refresh(c.backend, c.name); err != nil {
+				return fail(err)
+			}
+		} else {
+			if s, err = openSegment(c.backend, c.name, id, c.vectorSize, c.encoding, false); err != nil {
+				return fail(err)
+			}
+			opened = append(opened, s)
+		}
+		segments = append(segments, s)
+		c.nextID = max(c.nextID, id+1)
+		if i < len(m.Segments)-1 && s.ann == nil && c.indexType == IVFIndex {
+			if err := c.loadOrBuildANN(s); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	for i, s := range segments {
+		s.sealed = i < len(segments)-1
+	}
+	var dropped []*segment
+	for _, s := range c.segments {
+		if !slices.Contains(segments, s) {
+			dropped = append(dropped, s)
+		}
+	}
+	c.retire(dropped, false)
+	c.segments = segments
+	c.rebase()
+	return nil
+}
+
+// startFollowing refreshes the collection every interval until the collection is closed,
+// failed refresh is retried on the next tick
+func (c *Collection) startFollowing(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopFollowing = cancel
+	c.followDone = make(chan struct{})
+	go func() {
+		defer close(c.followDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.Refresh()
+			}
+		}
+	}()
+}
+
+// stopFollower cancels following and waits for the running refresh to finish, c.mu must not be held
+func (c *Collection) stopFollower() {
+	c.mu.Lock()
+	cancel, done := c.stopFollowing, c.followDone
+	c.stopFollowing = nil
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,218 @@
+package vech
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadOnlyDb(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	db, err := OpenDb(&OpenDbOptions{Path: path, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 0 {
+		t.Fatalf("collection length expected to be 0, actual: %d", c.Len())
+	}
+	if _, err := os.Stat(path + "/foo.idx"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("read only open is not expected to create files, stat returned: %v", err)
+	}
+	err = c.Add(chunks[0].vector, chunks[0].data)
+	if err == nil || !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("error expected to be ErrReadOnly, returned: %v", err)
+	}
+
+	wc, err := writer.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = addChunks(wc, chunks); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, chunks)
+	if err = c.Delete(1); err == nil || !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("error expected to be ErrReadOnly, returned: %v", err)
+	}
+}
+
+func TestFollower(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := CreateDb(&CreateDbOptions{VectorSize: 8, Path: path, SegmentSize: 1000, IndexType: IVFIndex})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	wc, err := writer.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomChunks(100, 8)
+	if err = addChunks(wc, data[:10]); err != nil {
+		t.Fatal(err)
+	}
+
+	follower, err := OpenDb(&OpenDbOptions{Path: path, ReadOnly: true, Follow: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+	c, err := follower.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, data[:10])
+
+	// the writer seals segments while the follower is refreshed in background
+	if err = addChunks(wc, data[10:]); err != nil {
+		t.Fatal(err)
+	}
+	if err = wc.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Len() != len(data) || c.Segments() != wc.Segments() {
+		if time.Now().After(deadline) {
+			t.Fatalf("follower expected to have %d records in %d segments, actual: %d in %d", len(data), wc.Segments(), c.Len(), c.Segments())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, err = c.Index(3); !errors.Is(err, ErrDeleted) {
+		t.Fatalf("error expected to be ErrDeleted, returned: %v", err)
+	}
+	for n := 4; n < len(data); n++ {
+		rec, err := c.Index(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := c.Data(rec.Position, rec.Size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(d) != string(data[n].data) {
+			t.Fatalf("data %d read %v does not match to original: %v", n, d, data[n].data)
+		}
+	}
+
+	if err = wc.Merge(&MergePolicy{SegmentsPerTier: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Segments() != wc.Segments() || c.Len() != wc.Len() {
+		t.Fatalf("follower expected to have %d records in %d segments, actual: %d in %d", wc.Len(), wc.Segments(), c.Len(), c.Segments())
+	}
+	res, err := c.CosineSim(data[50].vector, SortDesc, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Value < 0.999 {
+		t.Fatalf("the vector itself is expected to be found, result: %v", res)
+	}
+}
+
+func TestTornAdd(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = addChunks(c, chunks); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	idxSize, dataSize := fileSize(t, path+"/foo.idx"), fileSize(t, path+"/foo.data")
+	// add interrupted after the data and part of the index record were written
+	appendFile(t, path+"/foo.data", []byte{1, 2, 3})
+	appendFile(t, path+"/foo.idx", appendRecord(nil, dataSize, 3, 0, Float32, chunks[0].vector)[:10])
+
+	ro, err := OpenDb(&OpenDbOptions{Path: path, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err = ro.OpenCollection("foo"); err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, chunks)
+	ro.Close()
+	if size := fileSize(t, path+"/foo.idx"); size != idxSize+10 {
+		t.Fatalf("read only open is not expected to truncate the index, size: %d", size)
+	}
+
+	// writable instance without the exclusive lock keeps the files and refuses to append after the torn record
+	unlocked, err := OpenDb(&OpenDbOptions{Path: path, Lock: LockNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err = unlocked.OpenCollection("foo"); err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, chunks)
+	if err = c.Add(chunks[0].vector, chunks[0].data); !errors.Is(err, ErrNotRecovered) {
+		t.Fatalf("error expected to be ErrNotRecovered, returned: %v", err)
+	}
+	unlocked.Close()
+	if fileSize(t, path+"/foo.idx") != idxSize+10 || fileSize(t, path+"/foo.data") != dataSize+3 {
+		t.Fatal("open without exclusive lock is not expected to truncate the storages")
+	}
+
+	db, err = OpenFileDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err = db.OpenCollection("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if size := fileSize(t, path+"/foo.idx"); size != idxSize {
+		t.Fatalf("index expected to be truncated to %d, actual size: %d", idxSize, size)
+	}
+	if size := fileSize(t, path+"/foo.data"); size != dataSize {
+		t.Fatalf("data expected to be truncated to %d, actual size: %d", dataSize, size)
+	}
+	if err = c.Add(chunks[0].vector, chunks[0].data); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = OpenFileDb(path); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if c, err = db.OpenCollection("foo"); err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, append(chunks[:len(chunks):len(chunks)], chunks[0]))
+}
@@ -0,0 +1,118 @@
+package vech
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Aggregation combines scores of group members into the score of the group
+type Aggregation int
+
+const (
+	GroupMax  Aggregation = iota // value of the best member by the search order
+	GroupSum                     // sum of member values
+	GroupMean                    // mean of member values
+)
+
+// GroupOptions control the grouped search, nil options are the zero value
+type GroupOptions struct {
+	Field       string      // record field grouping results, records without the field are skipped
+	Order       SortType    // order of members and groups
+	Groups      int         // maximal amount of groups, 0 means all
+	PerGroup    int         // maximal amount of members returned per group, 0 means all
+	Aggregation Aggregation // combination of member values, all candidate members are aggregated
+	Candidates  int         // amount of the closest records grouped, 0 means default, negative means all records
+	WithData    bool        // read data of the members
+	WithVectors bool        // include vectors of the members
+}
+
+// Group is the result of grouped search
+type Group struct {
+	Key     string     // value of the grouping field
+	Value   float32    // aggregated value of the members
+	Members []Distance // the best members ordered by the search order
+}
+
+// SearchGroups searches the closest records and groups them by the value of the field, e.g. chunks
+// by their document. Groups are ordered by aggregated value of their members. By default 10 candidates
+// per returned member are grouped, all records are grouped if all groups are requested.
+func (c *Collection) SearchGroups(ctx context.Context, vector []float32, opt *GroupOptions) ([]Group, error) {
+	if opt == nil {
+		opt = &GroupOptions{}
+	}
+	if len(vector) != c.vectorSize {
+		return nil, fmt.Errorf("%w: collection vector size: %d, provided vector size: %d", ErrVectorSize, c.vectorSize, len(vector))
+	}
+	if opt.Field == "" || opt.Groups < 0 || opt.PerGroup < 0 ||
+		opt.Aggregation < GroupMax || opt.Aggregation > GroupMean {
+		return nil, fmt.Errorf("%w: %+v", ErrSearchOptions, *opt)
+	}
+	candidates := max(opt.Candidates, 0)
+	if opt.Candidates == 0 {
+		candidates = 10 * opt.Groups * max(opt.PerGroup, 1)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	res, err := c.search(ctx, vector, opt.Order, candidates)
+	if err != nil {
+		return nil, err
+	}
+	keys, found, err := c.fieldValues(res, opt.Field)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int)
+	var groups []Group
+	var sums []float64
+	for i, d := range res {
+		if !found[i] {
+			continue
+		}
+		g, ok := index[keys[i]]
+		if !ok {
+			g = len(groups)
+			index[keys[i]] = g
+			groups = append(groups, Group{Key: keys[i], Value: d.Value}) // results are ordered, the first is the best
+			sums = append(sums, 0)
+		}
+		groups[g].Members = append(groups[g].Members, d)
+		sums[g] += float64(d.Value)
+	}
+	for i := range groups {
+		switch opt.Aggregation {
+		case GroupSum:
+			groups[i].Value = float32(sums[i])
+		case GroupMean:
+			groups[i].Value = float32(sums[i] / float64(len(groups[i].Members)))
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if opt.Order == SortAsc {
+			return groups[i].Value < groups[j].Value
+		}
+		return groups[i].Value > groups[j].Value
+	})
+	if opt.Groups > 0 && len(groups) > opt.Groups {
+		groups = groups[:opt.Groups]
+	}
+	// members of all groups are filled at once, so data is read by few ordered reads
+	var members []Distance
+	for _, g := range groups {
+		if opt.PerGroup > 0 && len(g.Members) > opt.PerGroup {
+			g.Members = g.Members[:opt.PerGroup]
+		}
+		members = append(members, g.Members...)
+	}
+	if err := c.fillResults(members, opt.WithData, opt.WithVectors); err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		count := len(groups[i].Members)
+		if opt.PerGroup > 0 {
+			count = min(count, opt.PerGroup)
+		}
+		groups[i].Members, members = members[:count:count], members[count:]
+	}
+	return groups, nil
+}
@@ -0,0 +1,102 @@
+package vech
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSearchGroups(t *testing.T) {
+	c := newMemoryCollection(t, 4)
+	records := []struct {
+		doc    string
+		vector []float32
+	}{
+		{"a", []float32{1, 0.2, 0, 0}},
+		{"c", []float32{0.6, 0.8, 0, 0}},
+		{"a", []float32{1, 0.5, 0, 0}},
+		{"b", []float32{1, 0, 0, 0}},
+		{"c", []float32{0.6, 0, 0.8, 0}},
+		{"", []float32{1, 0.1, 0, 0}},
+		{"a", []float32{0.5, 1, 0, 0}},
+		{"c", []float32{0.6, 0, 0, 0.8}},
+		{"c", []float32{0.6, -0.8, 0, 0}},
+	}
+	for i, r := range records {
+		rec := Record{Vector: r.vector, Data: []byte{byte(i)}}
+		if r.doc != "" {
+			rec.Fields = map[string][]byte{"doc_id": []byte(r.doc)}
+		}
+		if err := c.AddRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	query := []float32{1, 0, 0, 0}
+	keys := func(groups []Group) string {
+		out := ""
+		for _, g := range groups {
+			out += g.Key
+		}
+		return out
+	}
+	for agg, expected := range map[Aggregation]string{GroupMax: "bac", GroupSum: "cab", GroupMean: "bac"} {
+		groups, err := c.SearchGroups(ctx, query, &GroupOptions{Field: "doc_id", Order: SortDesc, Aggregation: agg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys(groups) != expected {
+			t.Fatalf("aggregation %d expected to order groups %s, actual: %s", agg, expected, keys(groups))
+		}
+	}
+
+	groups, err := c.SearchGroups(ctx, query, &GroupOptions{Field: "doc_id", Order: SortDesc, Groups: 2, PerGroup: 2, WithData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys(groups) != "ba" || len(groups[0].Members) != 1 || len(groups[1].Members) != 2 {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+	a := groups[1]
+	if a.Members[0].N != 0 || a.Members[1].N != 2 || a.Value != a.Members[0].Value {
+		t.Fatalf("unexpected members of group a: %+v", a)
+	}
+	for _, g := range groups {
+		for _, m := range g.Members {
+			if m.Data[0] != byte(m.N) {
+				t.Fatalf("data of member %d does not match: %v", m.N, m.Data)
+			}
+		}
+	}
+
+	groups, err = c.SearchGroups(ctx, query, &GroupOptions{Field: "doc_id", Order: SortDesc, Candidates: 3, Aggregation: GroupSum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys(groups) != "ba" || len(groups[1].Members) != 1 {
+		t.Fatalf("only candidates expected to be grouped: %+v", groups)
+	}
+	if _, err = c.SearchGroups(ctx, query, &GroupOptions{}); !errors.Is(err, ErrSearchOptions) {
+		t.Fatalf("error expected to be ErrSearchOptions, returned: %v", err)
+	}
+
+	// the default amount of candidates is bounded, the far group wins by sum of all records only
+	c = newMemoryCollection(t, 4)
+	for i := 0; i < 25; i++ {
+		doc, vector := "near", []float32{1, 0.1, 0, 0}
+		if i >= 5 {
+			doc, vector = "far", []float32{1, 1, 0, 0}
+		}
+		if err = c.AddRecord(Record{Vector: vector, Fields: map[string][]byte{"doc_id": []byte(doc)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opt := GroupOptions{Field: "doc_id", Order: SortDesc, Groups: 1, PerGroup: 1, Aggregation: GroupSum}
+	if groups, err = c.SearchGroups(ctx, query, &opt); err != nil || keys(groups) != "near" {
+		t.Fatalf("group near expected to be found among default candidates: %+v, %v", groups, err)
+	}
+	opt.Candidates = -1
+	if groups, err = c.SearchGroups(ctx, query, &opt); err != nil || keys(groups) != "far" {
+		t.Fatalf("group far expected to be found among all records: %+v, %v", groups, err)
+	}
+}
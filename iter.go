@@ -0,0 +1,145 @@
+package vech
+
+import (
+	"bufio"
+	"io"
+	"iter"
+	"maps"
+)
+
+// iterBufferSize is the size of sequential data reads of iterators
+const iterBufferSize = 256 << 10
+
+// Record is the collection record yielded by iterators
+type Record struct {
+	N       int                  // record number
+	Vector  []float32            // vector, the mean of Vectors if it is not given for multi-vector record
+	Vectors [][]float32          // token vectors of multi-vector record, nil for single vector record
+	Sparse  *SparseVector        // sparse vector, nil if the record has no sparse vector
+	Named   map[string][]float32 // named vectors, nil if the record has no named vectors
+	Data    []byte               // payload, nil if the record has no data
+	Fields  map[string][]byte    // named fields, nil if the record has no fields
+}
+
+// All returns iterator over all live records of the collection, see Range
+func (c *Collection) All() iter.Seq2[Record, error] {
+	return c.Range(0, -1)
+}
+
+// Range returns iterator over live records with numbers from from to to exclusive, negative to means the end.
+// Records added after the iteration started are not yielded. Data of every segment is read sequentially.
+// Segments replaced by merges stay open until the iteration is done, so the loop body may call any method
+// of the collection. Numbers of yielded records are the numbers when the iteration started, Merge
+// renumbers records. The error stops the iteration, it is yielded with empty record.
+func (c *Collection) Range(from, to int) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		c.records(from, to, yield)
+	}
+}
+
+// records yields live records from from to to exclusive, the segments are pinned during the iteration
+func (c *Collection) records(from, to int, yield func(Record, error) bool) {
+	type segmentState struct {
+		seg      *segment
+		base     int
+		index    []byte
+		dataSize int
+		deleted  map[int]bool
+	}
+	c.mu.RLock()
+	if to < 0 || to > c.len() {
+		to = c.len()
+	}
+	dicts := c.dictionaries
+	var states []segmentState
+	var pinned []*segment
+	for _, s := range c.segments {
+		if s.base+s.len() > from && s.base < to {
+			states = append(states, segmentState{
+				seg:      s,
+				base:     s.base,
+				index:    s.index,
+				dataSize: s.dataSize,
+				deleted:  maps.Clone(s.deleted),
+			})
+			pinned = append(pinned, s)
+		}
+	}
+	c.pin(pinned)
+	c.mu.RUnlock()
+	defer c.unpin(pinned)
+
+	for _, st := range states {
+		it := segmentIterator{seg: st.seg, index: st.index, dataSize: st.dataSize, dicts: dicts}
+		start := max(from-st.base, 0)
+		end := min(to-st.base, len(st.index)/st.seg.recordSize)
+		for n := start; n < end; n++ {
+			if st.deleted[n] {
+				continue
+			}
+			rec, err := it.record(n)
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			rec.N = st.base + n
+			if !yield(rec, nil) {
+				return
+			}
+		}
+	}
+}
+
+// segmentIterator reads records of the segment in ascending order, data is read by buffered reader
+// and skipped records data is discarded, so the data storage is read sequentially
+type segmentIterator struct {
+	seg      *segment
+	index    []byte
+	dataSize int
+	dicts    [][]byte
+	reader   *bufio.Reader
+	pos      int // data position of the reader
+}
+
+func (it *segmentIterator) record(n int) (Record, error) {
+	s := it.seg
+	start := n * s.recordSize
+	pos, size, flags := decodeEntry(it.index[start : start+16])
+	rec := Record{Vector: s.encoding.decode(it.index[start+16 : start+s.recordSize])}
+	if size == 0 {
+		return rec, nil
+	}
+	if pos < 0 || pos+size > it.dataSize {
+		return Record{}, ErrDataPosition
+	}
+	// the reader is restarted instead of discarding long gap of skipped records
+	if it.reader == nil || pos < it.pos || pos-it.pos > iterBufferSize {
+		section := io.NewSectionReader(storageReaderAt{s.dataStorage}, int64(pos), int64(it.dataSize-pos))
+		it.reader = bufio.NewReaderSize(section, iterBufferSize)
+		it.pos = pos
+	}
+	if _, err := it.reader.Discard(pos - it.pos); err != nil {
+		return Record{}, err
+	}
+	blob := make([]byte, size)
+	if _, err := io.ReadFull(it.reader, blob); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrReadData
+		}
+		return Record{}, err
+	}
+	it.pos = pos + size
+	if err := decodeRecord(&rec, blob, flags, it.dicts, s.encoding, s.vectorSize); err != nil {
+		return Record{}, err
+	}
+	return rec, nil
+}
+
+// storageReaderAt adapts the storage to io.ReaderAt
+type storageReaderAt struct {
+	st storage
+}
+
+func (r storageReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return r.st.readAt(p, int(off))
+}
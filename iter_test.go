@@ -0,0 +1,138 @@
+package vech
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCollectionIterators(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path, SegmentSize: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomChunks(60, 4)
+	data[7].data = nil
+	if err = addChunks(c, data); err != nil {
+		t.Fatal(err)
+	}
+	deleted := map[int]bool{0: true, 13: true, 14: true, 59: true}
+	for n := range deleted {
+		if err = c.Delete(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(from, to int, seq func(yield func(Record, error) bool)) {
+		t.Helper()
+		expected := from
+		for rec, err := range seq {
+			if err != nil {
+				t.Fatal(err)
+			}
+			for deleted[expected] {
+				expected++
+			}
+			if rec.N != expected {
+				t.Fatalf("record %d expected, actual: %d", expected, rec.N)
+			}
+			d := data[rec.N]
+			if !reflect.DeepEqual(d.vector, rec.Vector) || !reflect.DeepEqual(d.data, rec.Data) {
+				t.Fatalf("record %d %v %v does not match to original: %v %v", rec.N, rec.Vector, rec.Data, d.vector, d.data)
+			}
+			expected++
+		}
+		for deleted[expected] {
+			expected++
+		}
+		if expected != to {
+			t.Fatalf("iteration expected to stop at %d, actual: %d", to, expected)
+		}
+	}
+	check(0, len(data), c.All())
+	check(5, 30, c.Range(5, 30))
+	check(40, len(data), c.Range(40, -1))
+	check(10, 10, c.Range(10, 5))
+
+	// records added by the loop body are not yielded
+	count := 0
+	for _, err := range c.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = c.Add(data[1].vector, data[1].data); err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+	if count != len(data)-len(deleted) {
+		t.Fatalf("expected %d records, actual: %d", len(data)-len(deleted), count)
+	}
+	count = 0
+	for range c.All() {
+		count++
+		if count == 10 {
+			break
+		}
+	}
+}
+
+func TestRangeLoopBody(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := setupDir("testdb-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDir("testdb-snapshot")
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path, SegmentSize: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomChunks(60, 4)
+	if err = addChunks(c, data); err != nil {
+		t.Fatal(err)
+	}
+	// export, snapshot and merge run from the loop body, segments replaced by merge are read to the end
+	n := 0
+	for rec, err := range c.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rec.Data, data[n].data) {
+			t.Fatalf("data of record %d does not match to original", n)
+		}
+		if n == 0 {
+			if err = c.Export(io.Discard); err != nil {
+				t.Fatal(err)
+			}
+			if err = db.Snapshot(snap); err != nil {
+				t.Fatal(err)
+			}
+			if err = c.Merge(&MergePolicy{SegmentsPerTier: 2}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		n++
+	}
+	if n != len(data) {
+		t.Fatalf("%d records expected to be yielded, actual: %d", len(data), n)
+	}
+	checkChunks(t, c, data)
+}
@@ -0,0 +1,95 @@
+package vech
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrLocked = errors.New("database is locked by another process")
+
+// LockMode defines inter-process access to the file database
+type LockMode int
+
+const (
+	LockDefault   LockMode = iota // exclusive lock of writable database, no lock of read-only database
+	LockNone                      // no locking, the caller guarantees the database is written by single process
+	LockShared                    // shared reader lock, held by any amount of read-only processes
+	LockExclusive                 // exclusive writer lock
+)
+
+// lockMode resolves the default lock mode
+func lockMode(mode LockMode, readOnly bool) LockMode {
+	if mode != LockDefault {
+		return mode
+	}
+	if readOnly {
+		return LockNone
+	}
+	return LockExclusive
+}
+
+const (
+	lockFile         = "vech.lock"
+	lockPollInterval = 10 * time.Millisecond
+)
+
+// errWouldBlock is returned by tryLock when the lock is held by another process
+var errWouldBlock = errors.New("lock would block")
+
+// dbLock is advisory lock of the database directory
+type dbLock struct {
+	f    *os.File
+	path string
+	mode LockMode
+}
+
+// acquireLock locks the database directory, it waits for the lock up to timeout
+func acquireLock(dir string, mode LockMode, timeout time.Duration) (*dbLock, error) {
+	if mode == LockNone {
+		return nil, nil
+	}
+	l := dbLock{path: dir + "/" + lockFile, mode: mode}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := l.tryLock()
+		if err == nil {
+			return &l, nil
+		}
+		if !errors.Is(err, errWouldBlock) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			if pid := lockOwner(l.path); pid > 0 {
+				return nil, fmt.Errorf("%w: %s held by pid %d", ErrLocked, l.path, pid)
+			}
+			return nil, fmt.Errorf("%w: %s", ErrLocked, l.path)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// writeOwner records the pid of the exclusive lock owner for diagnostics and stale lock detection
+func (l *dbLock) writeOwner() error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
+
+// lockOwner returns the pid recorded in the lock file, 0 if it is unknown
+func lockOwner(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
@@ -0,0 +1,55 @@
+//go:build !unix
+
+package vech
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// tryLock creates the lock file exclusively, shared locks are exclusive on this platform.
+// Lock file of the process that is not running anymore is stale and it is removed.
+func (l *dbLock) tryLock() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+	if err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%w: %s %s", ErrCreateFile, err.Error(), l.path)
+		}
+		if pid := lockOwner(l.path); pid > 0 && !processAlive(pid) {
+			if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			return l.tryLock()
+		}
+		return errWouldBlock
+	}
+	l.f = f
+	if err := l.writeOwner(); err != nil {
+		l.release()
+		return err
+	}
+	return nil
+}
+
+func (l *dbLock) release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	if rerr := os.Remove(l.path); err == nil {
+		err = rerr
+	}
+	l.f = nil
+	return err
+}
+
+// processAlive reports whether the process exists, FindProcess fails for absent process on windows
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,120 @@
+package vech
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = CreateDb(&CreateDbOptions{VectorSize: 4, Path: path, Lock: LockShared}); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("error expected to be ErrReadOnly, returned: %v", err)
+	}
+	writer, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path, Lock: LockExclusive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc, err := writer.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = addChunks(wc, chunks); err != nil {
+		t.Fatal(err)
+	}
+	if pid := lockOwner(path + "/" + lockFile); pid != os.Getpid() {
+		t.Fatalf("lock owner expected to be %d, actual: %d", os.Getpid(), pid)
+	}
+	for _, mode := range []LockMode{LockShared, LockExclusive} {
+		_, err = OpenDb(&OpenDbOptions{Path: path, Lock: mode, LockTimeout: 20 * time.Millisecond})
+		if err == nil || !errors.Is(err, ErrLocked) {
+			t.Fatalf("error expected to be ErrLocked, returned: %v", err)
+		}
+	}
+	if _, err = OpenDb(&OpenDbOptions{Path: path, Lock: LockNone}); err != nil {
+		t.Fatalf("unlocked open expected to succeed, returned: %v", err)
+	}
+	if _, err = OpenDb(&OpenDbOptions{Path: path, ReadOnly: true}); err != nil {
+		t.Fatalf("read only open expected to be unlocked by default, returned: %v", err)
+	}
+
+	// waiting for the lock released by writer
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		writer.Close()
+	}()
+	reader1, err := OpenDb(&OpenDbOptions{Path: path, Lock: LockShared, LockTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader2, err := OpenDb(&OpenDbOptions{Path: path, Lock: LockShared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenDb(&OpenDbOptions{Path: path, Lock: LockExclusive})
+	if err == nil || !errors.Is(err, ErrLocked) {
+		t.Fatalf("error expected to be ErrLocked, returned: %v", err)
+	}
+	// shared lock holders are read-only
+	c, err := reader1.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, c, chunks)
+	if err = c.Add(chunks[0].vector, chunks[0].data); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("error expected to be ErrReadOnly, returned: %v", err)
+	}
+	if err = c.Delete(0); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("error expected to be ErrReadOnly, returned: %v", err)
+	}
+	reader1.Close()
+	reader2.Close()
+
+	db, err := OpenDb(&OpenDbOptions{Path: path, Lock: LockExclusive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := (&fsBackend{path: path}).list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if name == lockFile {
+			t.Fatal("lock file is not expected to be listed as database file")
+		}
+	}
+}
+
+func TestStaleLock(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = created.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// lock file left by process that does not exist anymore
+	if err = os.WriteFile(path+"/"+lockFile, []byte("2147483646\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenDb(&OpenDbOptions{Path: path, Lock: LockExclusive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if pid := lockOwner(path + "/" + lockFile); pid != os.Getpid() {
+		t.Fatalf("lock owner expected to be %d, actual: %d", os.Getpid(), pid)
+	}
+}
@@ -0,0 +1,53 @@
+//go:build unix
+
+package vech
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// tryLock takes flock on the lock file. The kernel releases flock when the owner process dies,
+// so lock file left by crashed process is stale by definition and its owner record is overwritten.
+func (l *dbLock) tryLock() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("%w: %s %s", ErrCreateFile, err.Error(), l.path)
+	}
+	how := syscall.LOCK_SH
+	if l.mode == LockExclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return errWouldBlock
+		}
+		return err
+	}
+	l.f = f
+	if l.mode == LockExclusive {
+		if err := l.writeOwner(); err != nil {
+			l.release()
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *dbLock) release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	if l.mode == LockExclusive {
+		l.f.Truncate(0)
+	}
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
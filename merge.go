@@ -0,0 +1,367 @@
+package vech
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"time"
+)
+
+const mergeProgressStep = 1024
+
+// mergeCopyChunk is the size of data chunks copied by merge keeping deleted records
+const mergeCopyChunk = 1 << 20
+
+// MergePolicy controls merging of sealed segments.
+// Segments are grouped into tiers by their size, every tier is SegmentsPerTier times larger than the previous one.
+// When SegmentsPerTier adjacent sealed segments belong to the same tier they are merged into one segment
+// of the next tier. Deleted records are dropped by Merge and ANN index is rebuilt for the merged segment.
+type MergePolicy struct {
+	SegmentsPerTier int           // amount of adjacent segments of the same tier merged together, 10 if 0
+	MaxSegmentSize  int           // merged segment size limit in bytes, 0 means unlimited
+	DeletesRatio    float64       // sealed segment with larger share of deleted records is rewritten, 0 disables
+	Interval        time.Duration // background merge check period, merges are also checked after segment is sealed
+	BytesPerSecond  int           // merge write throttling, 0 means unlimited
+	Progress        func(MergeProgress)
+}
+
+// MergeProgress reports the state of the running merge
+type MergeProgress struct {
+	Segments []int // ids of merged segments
+	Records  int   // amount of processed records
+	Total    int   // amount of records in merged segments
+	Err      error // error that stopped the merge
+}
+
+func (p *MergePolicy) segmentsPerTier() int {
+	if p.SegmentsPerTier < 2 {
+		return 10
+	}
+	return p.SegmentsPerTier
+}
+
+func (p *MergePolicy) progress(mp MergeProgress) {
+	if p.Progress != nil {
+		p.Progress(mp)
+	}
+}
+
+// tier returns the tier of the segment size, base is the size of sealed segment
+func (p *MergePolicy) tier(size, base int) int {
+	if size <= base {
+		return 0
+	}
+	return int(math.Log(float64(size)/float64(base)) / math.Log(float64(p.segmentsPerTier())))
+}
+
+// liveSize estimates the segment size without deleted records
+func liveSize(s *segment) int {
+	if s.len() == 0 {
+		return 0
+	}
+	return s.size() * s.live() / s.len()
+}
+
+// selectMerge returns the positions of adjacent sealed segments to merge, nil if nothing to merge
+func (p *MergePolicy) selectMerge(segments []*segment, base int) []int {
+	sealed := segments[:len(segments)-1]
+	base = max(base, 1)
+	spt := p.segmentsPerTier()
+	for start := 0; start < len(sealed); {
+		tier := p.tier(liveSize(sealed[start]), base)
+		end := start + 1
+		for end < len(sealed) && p.tier(liveSize(sealed[end]), base) == tier {
+			end++
+		}
+		for i := start; i+spt <= end; i++ {
+			total := 0
+			for _, s := range sealed[i : i+spt] {
+				total += liveSize(s)
+			}
+			if p.MaxSegmentSize <= 0 || total <= p.MaxSegmentSize {
+				return span(i, i+spt)
+			}
+		}
+		start = end
+	}
+	if p.DeletesRatio > 0 {
+		for i, s := range sealed {
+			if s.len() > 0 && float64(len(s.deleted))/float64(s.len()) > p.DeletesRatio {
+				return []int{i}
+			}
+		}
+	}
+	return nil
+}
+
+func span(from, to int) []int {
+	out := make([]int, 0, to-from)
+	for i := from; i < to; i++ {
+		out = append(out, i)
+	}
+	return out
+}
+
+// Merge runs merges selected by the policy until there is nothing left to merge.
+// Merge renumbers records following the merged segments, so record numbers and data positions
+// obtained before the merge must not be used after it.
+func (c *Collection) Merge(p *MergePolicy) error {
+	return c.merge(context.Background(), p, true)
+}
+
+// MergeContext is Merge which stops when the context is done, the interrupted merge leaves segments unchanged
+func (c *Collection) MergeContext(ctx context.Context, p *MergePolicy) error {
+	return c.merge(ctx, p, true)
+}
+
+// StartMerging runs merges on background goroutine after every sealed segment and every policy interval.
+// Background merges keep record numbers and data positions, since callers may hold them: deleted records
+// are kept as tombstones and dropped by Merge only, DeletesRatio is ignored.
+// Merge errors are reported to policy Progress hook. The goroutine is stopped by Close.
+func (c *Collection) StartMerging(p *MergePolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopMerging != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopMerging = cancel
+	c.sealedNotify = make(chan struct{}, 1)
+	c.mergeDone = make(chan struct{})
+	background := *p
+	background.DeletesRatio = 0 // rewrite of the segment keeping deleted records reclaims nothing
+	go func() {
+		defer close(c.mergeDone)
+		var tick <-chan time.Time
+		if p.Interval > 0 {
+			ticker := time.NewTicker(p.Interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			case <-c.sealedNotify:
+			}
+			if err := c.merge(ctx, &background, false); err != nil && !errors.Is(err, context.Canceled) {
+				p.progress(MergeProgress{Err: err})
+			}
+		}
+	}()
+}
+
+// stopBackgroundMerge cancels background merging and waits for it to finish, c.mu must not be held
+func (c *Collection) stopBackgroundMerge() {
+	c.mu.Lock()
+	cancel, done := c.stopMerging, c.mergeDone
+	c.stopMerging = nil
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// merge runs merges selected by the policy, compact drops deleted records renumbering the following ones
+func (c *Collection) merge(ctx context.Context, p *MergePolicy, compact bool) error {
+	c.mergeMu.Lock()
+	defer c.mergeMu.Unlock()
+	for {
+		c.mu.RLock()
+		positions := p.selectMerge(c.segments, c.segmentSize)
+		var sources []*segment
+		for _, i := range positions {
+			sources = append(sources, c.segments[i])
+		}
+		c.mu.RUnlock()
+		if len(sources) == 0 {
+			return nil
+		}
+		if err := c.mergeSegments(ctx, p, sources, compact); err != nil {
+			return err
+		}
+	}
+}
+
+// mergeSegments writes records of adjacent sealed segments into new segment and replaces them. Compact merge
+// writes live records only, otherwise records and data are copied as stored, so numbers and positions are kept.
+func (c *Collection) mergeSegments(ctx context.Context, p *MergePolicy, sources []*segment, compact bool) error {
+	c.mu.Lock()
+	target, err := c.newSegment()
+	deleted := make([]map[int]bool, len(sources))
+	for i, s := range sources {
+		deleted[i] = make(map[int]bool, len(s.deleted))
+		for n := range s.deleted {
+			deleted[i][n] = true
+		}
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	progress := MergeProgress{}
+	for _, s := range sources {
+		progress.Segments = append(progress.Segments, s.id)
+		progress.Total += s.len()
+	}
+	// mapping of source records to target records, -1 for the dropped ones
+	mapping := make([][]int, len(sources))
+	started := time.Now()
+	written := 0
+	err = func() error {
+		for i, s := range sources {
+			mapping[i] = make([]int, s.len())
+			if !compact {
+				for n := range mapping[i] {
+					mapping[i][n] = target.len() + n
+				}
+				copied, err := copySegment(ctx, target, s, func(size int) {
+					written += size
+					throttle(ctx, started, written, p.BytesPerSecond)
+				})
+				if err != nil {
+					return err
+				}
+				progress.Records += copied
+				p.progress(progress)
+				continue
+			}
+			for n := 0; n < s.len(); n++ {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				progress.Records++
+				if progress.Records%mergeProgressStep == 0 {
+					p.progress(progress)
+				}
+				if deleted[i][n] {
+					mapping[i][n] = -1
+					continue
+				}
+				pos, size := s.entry(n)
+				var data []byte
+				if size > 0 {
+					var err error
+					if data, err = readStorage(s.dataStorage, pos, size); err != nil {
+						return err
+					}
+				}
+				mapping[i][n] = target.len()
+				if err := target.add(s.vector(n), data, s.flags(n)); err != nil {
+					return err
+				}
+				written += target.recordSize + len(data)
+				throttle(ctx, started, written, p.BytesPerSecond)
+			}
+		}
+		if err := target.indexStorage.closeWriter(); err != nil {
+			return err
+		}
+		if err := target.dataStorage.closeWriter(); err != nil {
+			return err
+		}
+		target.sealed = true
+		if c.indexType == IVFIndex {
+			return target.buildANN(c.backend, c.name, c.ivfLists, c.metric)
+		}
+		return nil
+	}()
+	if err != nil {
+		target.close()
+		removeSegment(c.backend, c.name, target.id)
+		progress.Err = err
+		p.progress(progress)
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// deleted records kept by the merge and records deleted while merge was running
+	for i, s := range sources {
+		for n := range s.deleted {
+			if m := mapping[i][n]; m >= 0 {
+				target.deleted[m] = true
+			}
+		}
+	}
+	if len(target.deleted) > 0 {
+		if err := target.saveDeleted(c.backend, c.name); err != nil {
+			return err
+		}
+	}
+	pos := 0
+	for i, s := range c.segments {
+		if s == sources[0] {
+			pos = i
+			break
+		}
+	}
+	segments := append([]*segment{}, c.segments[:pos]...)
+	segments = append(segments, target)
+	segments = append(segments, c.segments[pos+len(sources):]...)
+	old := c.segments
+	c.segments = segments
+	if err := writeManifest(c.backend, c.name, c.manifest()); err != nil {
+		c.segments = old
+		return err
+	}
+	c.rebase()
+	c.retire(sources, true)
+	p.progress(progress)
+	return nil
+}
+
+// copySegment appends records of the source segment to the target with the data as stored, the data
+// follows the data of previous sources, so positions in the collection data space do not change.
+// The amount of copied records is returned, written reports the amount of written bytes.
+func copySegment(ctx context.Context, target, s *segment, written func(int)) (int, error) {
+	dataWriter, err := target.dataStorage.writer()
+	if err != nil {
+		return 0, err
+	}
+	idxWriter, err := target.indexStorage.writer()
+	if err != nil {
+		return 0, err
+	}
+	for pos := 0; pos < s.dataSize; pos += mergeCopyChunk {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		data, err := readStorage(s.dataStorage, pos, min(mergeCopyChunk, s.dataSize-pos))
+		if err != nil {
+			return 0, err
+		}
+		if _, err := dataWriter.Write(data); err != nil {
+			return 0, err
+		}
+		written(len(data))
+	}
+	index := slices.Clone(s.index)
+	for p := 0; p < len(index); p += s.recordSize {
+		intToBytes(bytesToInt(index[p:])+target.dataSize, index[p:])
+	}
+	if _, err := idxWriter.Write(index); err != nil {
+		return 0, err
+	}
+	written(len(index))
+	target.index = append(target.index, index...)
+	target.dataSize += s.dataSize
+	return s.len(), nil
+}
+
+// throttle sleeps to keep written bytes within the rate limit
+func throttle(ctx context.Context, started time.Time, written, bytesPerSecond int) {
+	if bytesPerSecond <= 0 {
+		return
+	}
+	expected := time.Duration(float64(written) / float64(bytesPerSecond) * float64(time.Second))
+	if wait := expected - time.Since(started); wait > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+	}
+}
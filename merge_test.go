@@ -0,0 +1,288 @@
+package vech
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMergePolicySelect(t *testing.T) {
+	sized := func(sizes ...int) []*segment {
+		var out []*segment
+		for _, size := range sizes {
+			s := segment{recordSize: 10, index: make([]byte, size), deleted: make(map[int]bool)}
+			out = append(out, &s)
+		}
+		return append(out, &segment{recordSize: 10, deleted: make(map[int]bool)}) // active
+	}
+	p := MergePolicy{SegmentsPerTier: 3}
+	if m := p.selectMerge(sized(100, 100), 100); m != nil {
+		t.Fatalf("nothing expected to be merged, actual: %v", m)
+	}
+	if m := p.selectMerge(sized(300, 100, 100, 100), 100); !reflect.DeepEqual(m, []int{1, 2, 3}) {
+		t.Fatalf("segments 1-3 expected to be merged, actual: %v", m)
+	}
+	if m := p.selectMerge(sized(300, 300, 300, 100), 100); !reflect.DeepEqual(m, []int{0, 1, 2}) {
+		t.Fatalf("segments 0-2 expected to be merged, actual: %v", m)
+	}
+	p.MaxSegmentSize = 500
+	if m := p.selectMerge(sized(300, 300, 300, 100), 100); m != nil {
+		t.Fatalf("nothing expected to be merged over max size, actual: %v", m)
+	}
+	segments := sized(300, 100)
+	segments[1].deleted[0] = true
+	segments[1].deleted[1] = true
+	p.DeletesRatio = 0.1
+	if m := p.selectMerge(segments, 100); !reflect.DeepEqual(m, []int{1}) {
+		t.Fatalf("segment 1 expected to be rewritten, actual: %v", m)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := CreateDbOptions{
+		VectorSize:  4,
+		StorageType: FileSystem,
+		Path:        path,
+		SegmentSize: 2 * (4*4 + 16 + 3), // two records per segment
+		IndexType:   IVFIndex,
+	}
+	db, err := CreateDb(&opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomChunks(17, 4)
+	if err = addChunks(c, data); err != nil {
+		t.Fatal(err)
+	}
+	if c.Segments() != 9 {
+		t.Fatalf("segments amount expected to be 9, actual: %d", c.Segments())
+	}
+	for _, n := range []int{15, 5, 0} {
+		if err = c.Delete(n); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data[:n], data[n+1:]...)
+	}
+	var last MergeProgress
+	p := MergePolicy{
+		SegmentsPerTier: 4,
+		DeletesRatio:    0.3,
+		Progress:        func(mp MergeProgress) { last = mp },
+	}
+	if err = c.Merge(&p); err != nil {
+		t.Fatal(err)
+	}
+	// first 4 segments are merged, the result with deleted record stays in the first tier and is merged
+	// with the next 3 segments, the last sealed segment is rewritten to drop its deleted half
+	if c.Segments() != 3 {
+		t.Fatalf("segments amount expected to be 3, actual: %d", c.Segments())
+	}
+	if last.Records != last.Total || last.Err != nil {
+		t.Fatalf("last progress expected to be complete, actual: %+v", last)
+	}
+	if c.segments[0].ann == nil {
+		t.Fatal("ANN index of merged segment expected to be built")
+	}
+	checkChunks(t, c, data)
+	c.Close()
+
+	c, err = db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Segments() != 3 {
+		t.Fatalf("segments amount expected to be 3, actual: %d", c.Segments())
+	}
+	checkChunks(t, c, data)
+}
+
+func TestStartMerging(t *testing.T) {
+	opt := CreateDbOptions{
+		VectorSize:  4,
+		StorageType: Memory,
+		SegmentSize: 4*4 + 16 + 3,
+	}
+	db, err := CreateDb(&opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := make(chan MergeProgress, 100)
+	c.StartMerging(&MergePolicy{
+		SegmentsPerTier: 3,
+		BytesPerSecond:  1 << 20,
+		Progress:        func(mp MergeProgress) { merged <- mp },
+	})
+	data := randomChunks(3, 4)
+	if err = addChunks(c, data); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case mp := <-merged:
+		if mp.Err != nil {
+			t.Fatal(mp.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("background merge expected to run")
+	}
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.segments) != 2 {
+		t.Fatalf("segments amount expected to be 2, actual: %d", len(c.segments))
+	}
+	checkChunks(t, c, data)
+}
+
+func TestMergeContext(t *testing.T) {
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, StorageType: Memory, SegmentSize: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomChunks(40, 4)
+	if err = addChunks(c, data); err != nil {
+		t.Fatal(err)
+	}
+	segments := c.Segments()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = c.MergeContext(ctx, &MergePolicy{SegmentsPerTier: 2}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("error expected to be context.Canceled, returned: %v", err)
+	}
+	if c.Segments() != segments {
+		t.Fatalf("segments expected to stay unchanged %d, actual: %d", segments, c.Segments())
+	}
+	checkChunks(t, c, data)
+}
+
+func TestBackgroundMergeKeepsNumbers(t *testing.T) {
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, StorageType: Memory, SegmentSize: 4*4 + 16 + 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	data := randomChunks(3, 4)
+	if err = addChunks(c, data); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Delete(0); err != nil {
+		t.Fatal(err)
+	}
+	before, err := c.Index(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := make(chan MergeProgress, 100)
+	c.StartMerging(&MergePolicy{
+		SegmentsPerTier: 3,
+		DeletesRatio:    0.1,
+		Interval:        time.Millisecond,
+		Progress:        func(mp MergeProgress) { merged <- mp },
+	})
+	for c.Segments() != 2 {
+		select {
+		case mp := <-merged:
+			if mp.Err != nil {
+				t.Fatal(mp.Err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("background merge expected to run")
+		}
+	}
+	c.stopBackgroundMerge()
+	if c.Len() != 3 {
+		t.Fatalf("length expected to stay 3, actual: %d", c.Len())
+	}
+	if _, err = c.Get(0); !errors.Is(err, ErrDeleted) {
+		t.Fatalf("error expected to be ErrDeleted, returned: %v", err)
+	}
+	after, err := c.Index(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Fatalf("record expected to keep its index %+v, actual: %+v", before, after)
+	}
+	d, err := c.Data(after.Position, after.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d, data[2].data) {
+		t.Fatalf("data of the record expected to be kept, actual: %v", d)
+	}
+
+	// explicit merge drops the deleted record
+	if err = c.Merge(&MergePolicy{SegmentsPerTier: 3, DeletesRatio: 0.1}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 2 {
+		t.Fatalf("length expected to be 2 after merge, actual: %d", c.Len())
+	}
+	checkChunks(t, c, data[1:])
+}
+
+func TestMergePinnedSegments(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path, SegmentSize: 4*4 + 16 + 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomChunks(3, 4)
+	if err = addChunks(c, data); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.RLock()
+	pinned := slices.Clone(c.segments[:1])
+	c.pin(pinned)
+	c.mu.RUnlock()
+	if err = c.Merge(&MergePolicy{SegmentsPerTier: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Segments() != 2 {
+		t.Fatalf("segments amount expected to be 2, actual: %d", c.Segments())
+	}
+	// the pinned segment replaced by merge stays readable until it is unpinned
+	if _, err = pinned[0].data(0, len(data[0].data)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path + "/foo.idx"); err != nil {
+		t.Fatalf("files of the pinned segment expected to be kept, stat returned: %v", err)
+	}
+	c.unpin(pinned)
+	if _, err = os.Stat(path + "/foo.idx"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("files of the unpinned segment expected to be removed, stat returned: %v", err)
+	}
+	checkChunks(t, c, data)
+}
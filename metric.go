@@ -0,0 +1,140 @@
+package vech
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Metric is the function comparing vectors of the collection
+type Metric int
+
+const (
+	Cosine     Metric = iota // cosine similarity, higher is closer
+	DotProduct               // inner product, higher is closer
+	Euclidean                // euclidean distance, lower is closer
+)
+
+// distance compares the vectors, the sizes are verified by caller
+func (m Metric) distance(a, b []float32) float32 {
+	switch m {
+	case DotProduct:
+		return dotProduct(a, b)
+	case Euclidean:
+		return euclidean(a, b)
+	}
+	return cosineSim(a, b)
+}
+
+// closest returns the sort order placing the closest vectors first
+func (m Metric) closest() SortType {
+	if m == Euclidean {
+		return SortAsc
+	}
+	return SortDesc
+}
+
+func (m Metric) valid() bool {
+	return m >= Cosine && m <= Euclidean
+}
+
+// name returns the name of the metric used by archives
+func (m Metric) name() string {
+	switch m {
+	case DotProduct:
+		return "dot"
+	case Euclidean:
+		return "euclidean"
+	}
+	return "cosine"
+}
+
+func dotProduct(a, b []float32) float32 {
+	var s float32
+	for i, va := range a {
+		s += va * b[i]
+	}
+	return s
+}
+
+func euclidean(a, b []float32) float32 {
+	var s float64
+	for i, va := range a {
+		d := float64(va - b[i])
+		s += d * d
+	}
+	return float32(math.Sqrt(s))
+}
+
+// Encoding is the format of vectors stored in the collection index
+type Encoding int
+
+const (
+	Float32 Encoding = iota // 4 bytes per component
+	Float16                 // IEEE 754 half precision, 2 bytes per component
+)
+
+// size returns the amount of bytes per vector component
+func (e Encoding) size() int {
+	if e == Float16 {
+		return 2
+	}
+	return 4
+}
+
+func (e Encoding) valid() bool {
+	return e == Float32 || e == Float16
+}
+
+// name returns the name of the encoding used by archives
+func (e Encoding) name() string {
+	if e == Float16 {
+		return "float16"
+	}
+	return "float32"
+}
+
+// append appends encoded vector to dst
+func (e Encoding) append(dst []byte, vector []float32) []byte {
+	if e == Float16 {
+		for _, v := range vector {
+			dst = binary.LittleEndian.AppendUint16(dst, float32ToFloat16(v))
+		}
+		return dst
+	}
+	return append(dst, float32SliceToByte(vector)...)
+}
+
+// decode returns the vector, float32 vectors share memory with the encoded data
+func (e Encoding) decode(data []byte) []float32 {
+	if e == Float16 {
+		out := make([]float32, len(data)/2)
+		for i := range out {
+			out[i] = float16ToFloat32(binary.LittleEndian.Uint16(data[2*i:]))
+		}
+		return out
+	}
+	return bytesToFloat32Slice(data)
+}
+
+// decodeVectors splits encoded vectors of dims components, nil is returned for empty data.
+// The vectors are copied, since the data is not aligned.
+func (e Encoding) decodeVectors(data []byte, dims int) [][]float32 {
+	size := dims * e.size()
+	if len(data) < size || size == 0 {
+		return nil
+	}
+	count := len(data) / size
+	out := make([][]float32, count)
+	if e == Float16 {
+		for i := range out {
+			out[i] = e.decode(data[i*size : (i+1)*size])
+		}
+		return out
+	}
+	all := make([]float32, count*dims)
+	copy(float32SliceToByte(all), data)
+	for i := range out {
+		out[i] = all[i*dims : (i+1)*dims : (i+1)*dims]
+	}
+	return out
+}
@@ -0,0 +1,19 @@
+package vech
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMetric(t *testing.T) {
+	a, b := []float32{1, 2, 2}, []float32{2, 0, 0}
+	if v := Cosine.distance(a, b); math.Abs(float64(v)-1.0/3) > 1e-6 {
+		t.Fatalf("cosine similarity expected to be 1/3, actual: %v", v)
+	}
+	if v := DotProduct.distance(a, b); v != 2 {
+		t.Fatalf("dot product expected to be 2, actual: %v", v)
+	}
+	if v := Euclidean.distance(a, b); v != 3 {
+		t.Fatalf("euclidean distance expected to be 3, actual: %v", v)
+	}
+}
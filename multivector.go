@@ -0,0 +1,178 @@
+package vech
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Multi-vector records
+//
+// The record may hold variable number of token vectors besides its vector, e.g. vectors of ColBERT-style
+// late-interaction models. The token vectors are kept in the record data prefixed by uvarint byte length,
+// they are encoded like the collection vectors and compressed together with the payload. The record
+// vector is used by the collection index, it is the mean of the token vectors unless it is given.
+
+// encodeTokens returns the record vector and encoded token vectors prefixed by their length
+func (c *Collection) encodeTokens(vector []float32, vectors [][]float32) ([]float32, []byte, error) {
+	for _, v := range vectors {
+		if len(v) != c.vectorSize {
+			return nil, nil, fmt.Errorf("%w: collection vector size: %d, provided token vector size: %d", ErrVectorSize, c.vectorSize, len(v))
+		}
+	}
+	if len(vector) == 0 {
+		vector = meanVector(vectors)
+	}
+	size := len(vectors) * c.vectorSize * c.encoding.size()
+	tokens := binary.AppendUvarint(make([]byte, 0, size+binary.MaxVarintLen64), uint64(size))
+	for _, v := range vectors {
+		tokens = c.encoding.append(tokens, v)
+	}
+	return vector, tokens, nil
+}
+
+// splitSection splits decompressed record data into the section prefixed by its length and the rest of the data
+func splitSection(blob []byte) ([]byte, []byte, error) {
+	size, n := binary.Uvarint(blob)
+	if n <= 0 || size > uint64(len(blob)-n) {
+		return nil, nil, fmt.Errorf("%w: invalid record section size", ErrCorruptedDb)
+	}
+	end := n + int(size)
+	if end == len(blob) {
+		return blob[n:end], nil, nil
+	}
+	return blob[n:end:end], blob[end:], nil
+}
+
+// sectionSize returns the size of the section with its length prefix, which precedes the uncompressed data
+func sectionSize(seg *segment, pos, size int) (int, error) {
+	prefix, err := seg.data(pos, min(size, binary.MaxVarintLen64))
+	if err != nil {
+		return 0, err
+	}
+	length, n := binary.Uvarint(prefix)
+	if n <= 0 || length > uint64(size-n) {
+		return 0, fmt.Errorf("%w: invalid record section size", ErrCorruptedDb)
+	}
+	return n + int(length), nil
+}
+
+// meanVector returns the component-wise mean of the vectors
+func meanVector(vectors [][]float32) []float32 {
+	if len(vectors) == 0 {
+		return nil
+	}
+	mean := make([]float32, len(vectors[0]))
+	for _, v := range vectors {
+		for i, x := range v {
+			mean[i] += x
+		}
+	}
+	for i := range mean {
+		mean[i] /= float32(len(vectors))
+	}
+	return mean
+}
+
+// SearchMaxSim scores records by late interaction: the sum over the query vectors of the closest metric value
+// to the token vectors of the record. Records without token vectors are scored by their vector.
+// Candidates are the records closest to the mean of the query vectors, Candidates option 0 means 10 times Limit
+// and all records if Limit is 0 as well. Results are ordered closest first, the Order and diversification
+// options are ignored.
+func (c *Collection) SearchMaxSim(ctx context.Context, query [][]float32, opt *SearchOptions) ([]Distance, error) {
+	if opt == nil {
+		opt = &SearchOptions{}
+	}
+	if len(query) == 0 {
+		return nil, fmt.Errorf("%w: empty query", ErrSearchOptions)
+	}
+	for _, q := range query {
+		if len(q) != c.vectorSize {
+			return nil, fmt.Errorf("%w: collection vector size: %d, provided vector size: %d", ErrVectorSize, c.vectorSize, len(q))
+		}
+	}
+	if opt.Candidates < 0 {
+		return nil, fmt.Errorf("%w: %+v", ErrSearchOptions, *opt)
+	}
+	candidates := opt.Candidates
+	if candidates == 0 {
+		candidates = opt.Limit * 10
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	order := c.metric.closest()
+	res, err := c.search(ctx, meanVector(query), order, candidates)
+	if err != nil {
+		return nil, err
+	}
+	flags := make([]int, len(res))
+	for i, d := range res {
+		seg, err := c.segmentOf(d.N)
+		if err != nil {
+			return nil, err
+		}
+		flags[i] = seg.flags(d.N - seg.base)
+	}
+	// only token vectors are needed, but they are compressed together with the rest of the data
+	tokens := make([][][]float32, len(res))
+	var errs []error
+	err = c.readSorted(len(res), func(i int) (int, int) {
+		if flags[i]&multiFlag == 0 {
+			return res[i].Position, 0
+		}
+		return res[i].Position, res[i].Size
+	}, func(i int, data []byte) {
+		d, err := decodeData(data, flags[i], c.dictionaries)
+		tokens[i] = c.encoding.decodeVectors(d.tokens, c.vectorSize)
+		errs = append(errs, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	for i := range res {
+		if i%cancelCheckStep == cancelCheckStep-1 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		doc := tokens[i]
+		if len(doc) == 0 {
+			seg, err := c.segmentOf(res[i].N)
+			if err != nil {
+				return nil, err
+			}
+			doc = [][]float32{seg.vector(res[i].N - seg.base)}
+		}
+		res[i].Value = c.maxSim(query, doc)
+	}
+	sortDistances(res, order)
+	if opt.Limit > 0 && len(res) > opt.Limit {
+		res = res[:opt.Limit]
+	}
+	if err := c.fillResults(res, opt.WithData, opt.WithVectors); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// maxSim sums the closest metric values of the query vectors to the document vectors
+func (c *Collection) maxSim(query, doc [][]float32) float32 {
+	var sum float32
+	for _, q := range query {
+		best := c.metric.distance(q, doc[0])
+		for _, d := range doc[1:] {
+			v := c.metric.distance(q, d)
+			if c.metric.closest() == SortAsc {
+				best = min(best, v)
+			} else {
+				best = max(best, v)
+			}
+		}
+		sum += best
+	}
+	return sum
+}
@@ -0,0 +1,121 @@
+package vech
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMultiVector(t *testing.T) {
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, StorageType: Memory, SegmentSize: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = addChunks(c, randomChunks(20, 4)); err != nil {
+		t.Fatal(err)
+	}
+	both := Record{
+		Vectors: [][]float32{{1, 0, 0, 0}, {0, 1, 0, 0}},
+		Data:    []byte("both"),
+		Fields:  map[string][]byte{"title": []byte("first")},
+	}
+	one := Record{Vectors: [][]float32{{1, 0, 0, 0}, {0, 0, 1, 0}}, Data: bytes.Repeat([]byte("one "), 40)}
+	single := Record{Vector: []float32{1, 1, 0, 0}, Data: []byte("single")}
+	if err = c.AddRecord(both); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SetCompression(Flate, 6); err != nil {
+		t.Fatal(err)
+	}
+	w := c.BulkWriter(0)
+	if err = w.AddRecord(one); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.AddRecord(single); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.AddRecord(Record{Vectors: [][]float32{{1, 2, 3}}}); !errors.Is(err, ErrVectorSize) {
+		t.Fatalf("error expected to be ErrVectorSize, returned: %v", err)
+	}
+
+	rec, err := c.Get(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rec.Vectors, both.Vectors) || !reflect.DeepEqual(rec.Vector, []float32{0.5, 0.5, 0, 0}) {
+		t.Fatalf("unexpected vectors of multi-vector record: %v %v", rec.Vector, rec.Vectors)
+	}
+	if string(rec.Data) != "both" || string(rec.Fields["title"]) != "first" {
+		t.Fatalf("unexpected data of multi-vector record: %+v", rec)
+	}
+	fields, err := c.GetFields(20, "title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fields["title"]) != "first" {
+		t.Fatalf("unexpected fields of multi-vector record: %v", fields)
+	}
+	rec, err = c.Get(21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rec.Vectors, one.Vectors) || !bytes.Equal(rec.Data, one.Data) {
+		t.Fatalf("compressed multi-vector record does not match to original: %+v", rec)
+	}
+	if rec, err = c.Get(22); err != nil || rec.Vectors != nil {
+		t.Fatalf("single vector record expected to have no token vectors: %+v, %v", rec, err)
+	}
+
+	ctx := context.Background()
+	query := [][]float32{{1, 0, 0, 0}, {0, 1, 0, 0}}
+	res, err := c.SearchMaxSim(ctx, query, &SearchOptions{Limit: 3, WithData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 || string(res[0].Data) != "both" || string(res[1].Data) != "single" {
+		t.Fatalf("record with matching tokens expected to precede the record of the same mean vector: %v", res)
+	}
+	for _, r := range res {
+		data, err := c.Data(r.Position, r.Size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, r.Data) {
+			t.Fatalf("data of record %d %q does not match to payload: %q", r.N, data, r.Data)
+		}
+	}
+	if res[0].Value < 1.999 || res[0].Value > 2.001 {
+		t.Fatalf("MaxSim of matching tokens expected to be 2, actual: %f", res[0].Value)
+	}
+	if _, err = c.SearchMaxSim(ctx, nil, &SearchOptions{}); !errors.Is(err, ErrSearchOptions) {
+		t.Fatalf("error expected to be ErrSearchOptions, returned: %v", err)
+	}
+
+	var archive bytes.Buffer
+	if err = c.Export(&archive); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := db.OpenCollection("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = imported.Import(&archive); err != nil {
+		t.Fatal(err)
+	}
+	rec, err = imported.Get(21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rec.Vectors, one.Vectors) {
+		t.Fatalf("imported token vectors %v do not match to original: %v", rec.Vectors, one.Vectors)
+	}
+}
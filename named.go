@@ -0,0 +1,222 @@
+package vech
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Named vectors
+//
+// The collection may declare named vectors with their own size and metric, e.g. embeddings of title and body
+// of the chunk by different models. Records hold any of them besides the record vector and share single payload
+// and fields. Named vectors are kept in the record data after sparse vector, prefixed by uvarint byte length:
+// uvarint count of the vectors followed by name string, uvarint size and the components encoded like
+// the collection vectors of every vector. Named vector search loads the vectors of the segment into memory
+// by the first search and scans them.
+
+var ErrVectorName = errors.New("unknown vector name")
+
+// NamedVector declares named vector of the collection records
+type NamedVector struct {
+	Name   string
+	Size   int    // vector dimensions
+	Metric Metric // vector comparison function
+}
+
+// VectorQuery is the query vector of the named vector
+type VectorQuery struct {
+	Name   string    // named vector, empty means the record vector
+	Vector []float32 // query vector
+	Weight float32   // weight of the metric value in the combined score, 0 means 1
+}
+
+// namedVector returns declaration of the named vector, empty name means the record vector
+func (c *Collection) namedVector(name string) (NamedVector, error) {
+	if name == "" {
+		return NamedVector{Size: c.vectorSize, Metric: c.metric}, nil
+	}
+	i := slices.IndexFunc(c.named, func(v NamedVector) bool { return v.Name == name })
+	if i < 0 {
+		return NamedVector{}, fmt.Errorf("%w: %q", ErrVectorName, name)
+	}
+	return c.named[i], nil
+}
+
+// encodeNamed returns encoded named vectors prefixed by their length, the vectors are ordered by name
+func (c *Collection) encodeNamed(named map[string][]float32) ([]byte, error) {
+	names := make([]string, 0, len(named))
+	for name, v := range named {
+		decl, err := c.namedVector(name)
+		if err != nil || name == "" {
+			return nil, fmt.Errorf("%w: %q", ErrVectorName, name)
+		}
+		if len(v) != decl.Size {
+			return nil, fmt.Errorf("%w: vector %s size: %d, provided vector size: %d", ErrVectorSize, name, decl.Size, len(v))
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	body := binary.AppendUvarint(nil, uint64(len(names)))
+	for _, name := range names {
+		body = binary.AppendUvarint(body, uint64(len(name)))
+		body = append(body, name...)
+		body = binary.AppendUvarint(body, uint64(len(named[name])))
+		body = c.encoding.append(body, named[name])
+	}
+	return append(binary.AppendUvarint(nil, uint64(len(body))), body...), nil
+}
+
+// decodeNamed decodes named vectors, nil is returned for empty data
+func decodeNamed(data []byte, enc Encoding) (map[string][]float32, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	invalid := fmt.Errorf("%w: invalid named vectors", ErrCorruptedDb)
+	count, pos := binary.Uvarint(data)
+	if pos <= 0 || count > uint64(len(data)) {
+		return nil, invalid
+	}
+	out := make(map[string][]float32, count)
+	for range count {
+		length, n := binary.Uvarint(data[pos:])
+		if n <= 0 || length > uint64(len(data)-pos-n) {
+			return nil, invalid
+		}
+		pos += n
+		name := string(data[pos : pos+int(length)])
+		pos += int(length)
+		size, n := binary.Uvarint(data[pos:])
+		if n <= 0 || size == 0 || size > uint64(len(data)-pos-n)/uint64(enc.size()) {
+			return nil, invalid
+		}
+		pos += n
+		end := pos + int(size)*enc.size()
+		out[name] = enc.decodeVectors(data[pos:end], int(size))[0]
+		pos = end
+	}
+	return out, nil
+}
+
+// namedIndex keeps named vectors of segment records in memory
+type namedIndex struct {
+	mu      sync.Mutex             // guards loading by concurrent searches
+	records []map[string][]float32 // named vectors of loaded records, nil if the record has none
+}
+
+// indexNamed loads named vectors of segment records added since the previous call, c.mu has to be held
+func (c *Collection) indexNamed(seg *segment) error {
+	ix := seg.named
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	from := len(ix.records)
+	count := seg.len() - from
+	vectors := make([]map[string][]float32, count)
+	var errs []error
+	err := c.readSorted(count, func(i int) (int, int) {
+		n := from + i
+		pos, size := seg.entry(n)
+		if seg.deleted[n] || seg.flags(n)&namedFlag == 0 {
+			return seg.dataBase + pos, 0
+		}
+		return seg.dataBase + pos, size
+	}, func(i int, data []byte) {
+		d, err := decodeData(data, seg.flags(from+i), c.dictionaries)
+		if err == nil {
+			vectors[i], err = decodeNamed(d.named, c.encoding)
+		}
+		errs = append(errs, err)
+	})
+	if err != nil {
+		return err
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	ix.records = append(ix.records, vectors...)
+	return nil
+}
+
+// SearchNamed compares the query vectors to the named vectors of the records by their metrics, the Value
+// of the result is the weighted sum of the metric values. Records without any of the queried vectors are skipped.
+// Metrics of the queried vectors have to place the closest vectors in the same order, the results are ordered
+// closest first and the Order and diversification options are ignored. Single query of the record vector
+// is searched by the collection index.
+func (c *Collection) SearchNamed(ctx context.Context, queries []VectorQuery, opt *SearchOptions) ([]Distance, error) {
+	if opt == nil {
+		opt = &SearchOptions{}
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("%w: empty query", ErrSearchOptions)
+	}
+	decls := make([]NamedVector, len(queries))
+	weights := make([]float64, len(queries))
+	for i, q := range queries {
+		decl, err := c.namedVector(q.Name)
+		if err != nil {
+			return nil, err
+		}
+		if len(q.Vector) != decl.Size {
+			return nil, fmt.Errorf("%w: vector %q size: %d, provided vector size: %d", ErrVectorSize, q.Name, decl.Size, len(q.Vector))
+		}
+		if i > 0 && decl.Metric.closest() != decls[0].Metric.closest() {
+			return nil, fmt.Errorf("%w: metrics of vectors %q and %q can not be combined", ErrSearchOptions, queries[0].Name, q.Name)
+		}
+		decls[i], weights[i] = decl, float64(q.Weight)
+		if q.Weight == 0 {
+			weights[i] = 1
+		}
+	}
+	order := decls[0].Metric.closest()
+	if len(queries) == 1 && queries[0].Name == "" && weights[0] == 1 {
+		return c.Search(ctx, queries[0].Vector, &SearchOptions{Order: order, Limit: opt.Limit, WithData: opt.WithData, WithVectors: opt.WithVectors})
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	res := make([]Distance, 0, c.len())
+	for _, seg := range c.segments {
+		if err := c.indexNamed(seg); err != nil {
+			return nil, err
+		}
+		// loading is complete, so the vectors are not modified while the collection lock is held
+		for n, named := range seg.named.records {
+			if n%cancelCheckStep == cancelCheckStep-1 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+			}
+			if seg.deleted[n] {
+				continue
+			}
+			var score float64
+			found := true
+			for i, q := range queries {
+				v := named[q.Name]
+				if q.Name == "" {
+					v = seg.vector(n)
+				}
+				if v == nil {
+					found = false
+					break
+				}
+				score += weights[i] * float64(decls[i].Metric.distance(q.Vector, v))
+			}
+			if found {
+				pos, size := seg.entry(n)
+				res = append(res, Distance{N: seg.base + n, Value: float32(score), Position: seg.dataBase + pos, Size: size})
+			}
+		}
+	}
+	sortDistances(res, order)
+	if opt.Limit > 0 && len(res) > opt.Limit {
+		res = res[:opt.Limit]
+	}
+	if err := c.fillResults(res, opt.WithData, opt.WithVectors); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,139 @@
+package vech
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestNamedVectors(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, Path: path, SegmentSize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := [][]NamedVector{{{Name: "", Size: 2}}, {{Name: "a", Size: 0}}, {{Name: "a", Size: 2}, {Name: "a", Size: 3}}}
+	for _, vectors := range invalid {
+		if _, err = db.CreateCollection("foo", &CollectionOptions{Vectors: vectors}); !errors.Is(err, ErrCollectionOptions) {
+			t.Fatalf("named vectors %v expected to be invalid, returned: %v", vectors, err)
+		}
+	}
+	opt := &CollectionOptions{Vectors: []NamedVector{{Name: "title", Size: 3}, {Name: "body", Size: 5}, {Name: "place", Size: 2, Metric: Euclidean}}}
+	c, err := db.CreateCollection("foo", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := randomChunks(40, 4)
+	titles := randomChunks(41, 3)[1:]
+	bodies := randomChunks(42, 5)[2:]
+	for i, d := range chunks {
+		rec := Record{Vector: d.vector, Data: d.data, Named: map[string][]float32{"title": titles[i].vector}}
+		// every fourth record has no body vector
+		if i%4 != 3 {
+			rec.Named["body"] = bodies[i].vector
+		}
+		if i == 5 {
+			rec.Fields = map[string][]byte{"lang": []byte("en")}
+		}
+		if err = c.AddRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = c.AddRecord(Record{Vector: chunks[0].vector, Named: map[string][]float32{"summary": {1, 2, 3}}}); !errors.Is(err, ErrVectorName) {
+		t.Fatalf("error expected to be ErrVectorName, returned: %v", err)
+	}
+	if err = c.AddRecord(Record{Vector: chunks[0].vector, Named: map[string][]float32{"title": {1, 2}}}); !errors.Is(err, ErrVectorSize) {
+		t.Fatalf("error expected to be ErrVectorSize, returned: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenFileDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c, err = db.OpenExistingCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Options().Vectors, opt.Vectors) {
+		t.Fatalf("named vectors %v do not match to created: %v", c.Options().Vectors, opt.Vectors)
+	}
+	rec, err := c.Get(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rec.Named, map[string][]float32{"title": titles[5].vector, "body": bodies[5].vector}) {
+		t.Fatalf("unexpected named vectors: %v", rec.Named)
+	}
+	fields, err := c.GetFields(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fields["lang"]) != "en" {
+		t.Fatalf("unexpected fields of record with named vectors: %v", fields)
+	}
+
+	ctx := context.Background()
+	res, err := c.SearchNamed(ctx, []VectorQuery{{Name: "title", Vector: titles[9].vector}}, &SearchOptions{Limit: 3, WithData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].N != 9 || !bytes.Equal(res[0].Data, chunks[9].data) {
+		t.Fatalf("title search expected to return record 9 first, results: %v", res)
+	}
+	if data, err := c.Data(res[0].Position, res[0].Size); err != nil || !bytes.Equal(data, chunks[9].data) {
+		t.Fatalf("data of record with named vectors %q does not match to payload: %v", data, err)
+	}
+	query := []VectorQuery{{Name: "title", Vector: titles[6].vector, Weight: 0.5}, {Name: "body", Vector: bodies[6].vector}}
+	res, err = c.SearchNamed(ctx, query, &SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 30 {
+		t.Fatalf("records without body vector expected to be skipped, results: %d", len(res))
+	}
+	if res[0].N != 6 || math.Abs(float64(res[0].Value)-1.5) > 1e-5 {
+		t.Fatalf("combined search expected to return record 6 with score 1.5 first, results: %v", res[:3])
+	}
+	res, err = c.SearchNamed(ctx, []VectorQuery{{Vector: chunks[2].vector}}, &SearchOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].N != 2 {
+		t.Fatalf("record vector search expected to return record 2, results: %v", res)
+	}
+	if _, err = c.SearchNamed(ctx, []VectorQuery{{Name: "title", Vector: titles[0].vector}, {Name: "place", Vector: []float32{0, 0}}}, &SearchOptions{}); !errors.Is(err, ErrSearchOptions) {
+		t.Fatalf("error expected to be ErrSearchOptions, returned: %v", err)
+	}
+	if _, err = c.SearchNamed(ctx, []VectorQuery{{Name: "summary", Vector: titles[0].vector}}, &SearchOptions{}); !errors.Is(err, ErrVectorName) {
+		t.Fatalf("error expected to be ErrVectorName, returned: %v", err)
+	}
+
+	var archive bytes.Buffer
+	if err = c.Export(&archive); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := db.CreateCollection("bar", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = imported.Import(&archive); err != nil {
+		t.Fatal(err)
+	}
+	imp, err := imported.Get(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imp.Named, map[string][]float32{"title": titles[3].vector}) {
+		t.Fatalf("imported named vectors %v do not match to original", imp.Named)
+	}
+}
@@ -0,0 +1,178 @@
+package vech
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// RecommendStrategy is the way the vectors of example records are combined by Recommend
+type RecommendStrategy int
+
+const (
+	// AverageVector searches by the mean of positive vectors moved away from the mean of negative vectors,
+	// the search uses the collection index
+	AverageVector RecommendStrategy = iota
+	// BestScore scores every record by its closest positive example, records closer to a negative example
+	// than to any positive one are placed after the others
+	BestScore
+)
+
+// RecommendOptions control the search of records similar to example records, nil options are the zero value
+type RecommendOptions struct {
+	Strategy    RecommendStrategy // way of combining example vectors
+	Limit       int               // maximal amount of results, 0 means all
+	WithData    bool              // read data of the results
+	WithVectors bool              // include vectors of the results
+}
+
+// Recommend returns records similar to positive and dissimilar to negative example records, the examples
+// are not included in the results. At least one positive example is required.
+// AverageVector results are ordered and valued like Search by the collection metric. BestScore results
+// closer to a positive example are ordered by their closeness to it, the others follow ordered away from
+// the negative example, the Value is the sigmoid of the closeness in [0, 1] negated for the latter.
+func (c *Collection) Recommend(ctx context.Context, positive, negative []int, opt *RecommendOptions) ([]Distance, error) {
+	if opt == nil {
+		opt = &RecommendOptions{}
+	}
+	if len(positive) == 0 || opt.Strategy != AverageVector && opt.Strategy != BestScore {
+		return nil, fmt.Errorf("%w: positive %v, %+v", ErrSearchOptions, positive, *opt)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	pos, err := c.exampleVectors(positive)
+	if err != nil {
+		return nil, err
+	}
+	neg, err := c.exampleVectors(negative)
+	if err != nil {
+		return nil, err
+	}
+	examples := slices.Compact(slices.Sorted(slices.Values(slices.Concat(positive, negative))))
+
+	var res []Distance
+	if opt.Strategy == AverageVector {
+		limit := opt.Limit
+		if limit > 0 {
+			limit += len(examples)
+		}
+		if res, err = c.search(ctx, averageVector(pos, neg), c.metric.closest(), limit); err != nil {
+			return nil, err
+		}
+		res = slices.DeleteFunc(res, func(d Distance) bool {
+			_, found := slices.BinarySearch(examples, d.N)
+			return found
+		})
+	} else if res, err = c.bestScore(ctx, pos, neg, examples); err != nil {
+		return nil, err
+	}
+	if opt.Limit > 0 && len(res) > opt.Limit {
+		res = res[:opt.Limit]
+	}
+	if err := c.fillResults(res, opt.WithData, opt.WithVectors); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// exampleVectors returns vectors of live records ns, c.mu has to be held
+func (c *Collection) exampleVectors(ns []int) ([][]float32, error) {
+	out := make([][]float32, len(ns))
+	for i, n := range ns {
+		seg, err := c.segmentOf(n)
+		if err != nil {
+			return nil, fmt.Errorf("%w: record %d", err, n)
+		}
+		if seg.deleted[n-seg.base] {
+			return nil, fmt.Errorf("%w: record %d", ErrDeleted, n)
+		}
+		out[i] = seg.vector(n - seg.base)
+	}
+	return out, nil
+}
+
+// averageVector returns mean of positive vectors moved from mean of negative vectors by their difference
+func averageVector(pos, neg [][]float32) []float32 {
+	avg := meanVector(pos)
+	if len(neg) == 0 {
+		return avg
+	}
+	out := make([]float32, len(avg))
+	for i, v := range meanVector(neg) {
+		out[i] = 2*avg[i] - v
+	}
+	return out
+}
+
+// bestScore scans all live records except the examples, c.mu has to be held
+func (c *Collection) bestScore(ctx context.Context, positives, negatives [][]float32, examples []int) ([]Distance, error) {
+	// closeness is higher for closer vectors whatever the metric is
+	closeness := func(a, b []float32) float64 {
+		v := float64(c.metric.distance(a, b))
+		if c.metric.closest() == SortAsc {
+			return -v
+		}
+		return v
+	}
+	best := func(vectors [][]float32, v []float32) float64 {
+		b := math.Inf(-1)
+		for _, e := range vectors {
+			b = max(b, closeness(e, v))
+		}
+		return b
+	}
+	sigmoid := func(x float64) float64 { return 1 / (1 + math.Exp(-x)) }
+
+	// the sigmoid saturates for large metric values, so the results are ranked by the closeness
+	type scored struct {
+		d         Distance
+		negative  bool    // closer to a negative example
+		closeness float64 // to the best positive or negative example
+	}
+	all := make([]scored, 0, c.len())
+	for _, seg := range c.segments {
+		for n := range seg.len() {
+			if n%cancelCheckStep == cancelCheckStep-1 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+			}
+			if _, found := slices.BinarySearch(examples, seg.base+n); found || seg.deleted[n] {
+				continue
+			}
+			v := seg.vector(n)
+			p, q := best(positives, v), best(negatives, v)
+			s := scored{negative: q > p, closeness: p}
+			s.d.Value = float32(sigmoid(p))
+			if s.negative {
+				s.closeness, s.d.Value = q, -float32(sigmoid(q))
+			}
+			pos, size := seg.entry(n)
+			s.d.N, s.d.Position, s.d.Size = seg.base+n, seg.dataBase+pos, size
+			all = append(all, s)
+		}
+	}
+	slices.SortFunc(all, func(a, b scored) int {
+		if a.negative != b.negative {
+			if a.negative {
+				return 1
+			}
+			return -1
+		}
+		c := cmp.Compare(b.closeness, a.closeness)
+		if a.negative {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+		return cmp.Compare(a.d.N, b.d.N)
+	})
+	res := make([]Distance, len(all))
+	for i, s := range all {
+		res[i] = s.d
+	}
+	return res, nil
+}
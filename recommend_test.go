@@ -0,0 +1,127 @@
+package vech
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestRecommend(t *testing.T) {
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, StorageType: Memory, SegmentSize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, opt := range []*CollectionOptions{{IndexType: FlatIndex}, {IndexType: IVFIndex, IVFLists: 4, IVFProbes: 4}, {Metric: Euclidean}} {
+		c, err := db.CreateCollection("foo", opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks := randomChunks(100, 4)
+		if err = addChunks(c, chunks); err != nil {
+			t.Fatal(err)
+		}
+		// record 100 duplicates the negative example
+		if err = c.Add(chunks[11].vector, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err = c.Delete(12); err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := c.Recommend(ctx, []int{10}, nil, &RecommendOptions{Limit: 5, WithData: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		similar, err := c.Search(ctx, chunks[10].vector, &SearchOptions{Order: opt.Metric.closest(), Limit: 6})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 5 || res[0].N != similar[1].N || res[0].Data == nil {
+			t.Fatalf("recommendation %v expected to match search results except the example: %v", res, similar)
+		}
+		for _, r := range res {
+			if r.N == 10 {
+				t.Fatalf("example record expected to be excluded: %v", res)
+			}
+		}
+
+		for _, strategy := range []RecommendStrategy{AverageVector, BestScore} {
+			position := func(negative []int) (int, Distance) {
+				t.Helper()
+				res, err := c.Recommend(ctx, []int{10, 20}, negative, &RecommendOptions{Strategy: strategy})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(res) != c.Len()-3-len(negative) {
+					t.Fatalf("strategy %d expected to return all live records except examples, results: %d", strategy, len(res))
+				}
+				i := slices.IndexFunc(res, func(d Distance) bool { return d.N == 100 })
+				return i, res[i]
+			}
+			before, _ := position(nil)
+			after, d := position([]int{11})
+			if after <= before {
+				t.Fatalf("strategy %d: copy of negative example expected to move down from position %d, actual: %d", strategy, before, after)
+			}
+			if strategy == BestScore && (d.Value >= 0 || after < c.Len()-10) {
+				t.Fatalf("copy of negative example expected to be among the last results with negative score: %v at %d", d, after)
+			}
+		}
+
+		if _, err = c.Recommend(ctx, []int{12}, nil, &RecommendOptions{}); !errors.Is(err, ErrDeleted) {
+			t.Fatalf("error expected to be ErrDeleted, returned: %v", err)
+		}
+		if _, err = c.Recommend(ctx, nil, []int{1}, &RecommendOptions{}); !errors.Is(err, ErrSearchOptions) {
+			t.Fatalf("error expected to be ErrSearchOptions, returned: %v", err)
+		}
+		if err = c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err = db.DropCollection("foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRecommendBestScoreUnbounded(t *testing.T) {
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, StorageType: Memory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, metric := range []Metric{DotProduct, Euclidean} {
+		c, err := db.CreateCollection("foo", &CollectionOptions{Metric: metric})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// examples 0 and 1, the metric values of the others are far beyond the sigmoid saturation
+		vectors := [][]float32{{10, 0, 0, 0}, {0, -10, 0, 0}, {20, 0, 0, 0}, {2, 0, 0, 0}, {50, 0, 0, 0}, {0, -30, 0, 0}, {0, -5, 0, 0}}
+		for _, v := range vectors {
+			if err = c.Add(v, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		res, err := c.Recommend(context.Background(), []int{0}, []int{1}, &RecommendOptions{Strategy: BestScore})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []int{4, 2, 3, 6, 5}
+		if metric == Euclidean {
+			expected = []int{3, 2, 4, 5, 6}
+		}
+		ns := make([]int, len(res))
+		for i, r := range res {
+			ns[i] = r.N
+		}
+		if !slices.Equal(ns, expected) {
+			t.Fatalf("metric %d: results expected to be ordered %v, actual: %v", metric, expected, ns)
+		}
+		if err = c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err = db.DropCollection("foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
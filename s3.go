@@ -0,0 +1,477 @@
+package vech
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrS3Request = errors.New("s3 request error")
+	ErrReadOnly  = errors.New("storage is read only")
+)
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Options are used to access database published to S3 compatible object storage
+type S3Options struct {
+	Endpoint  string       // service url, e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Region    string       // signing region, us-east-1 if empty
+	Bucket    string       // bucket name
+	Prefix    string       // key prefix of the database, analogue of the database path
+	AccessKey string       // access key id
+	SecretKey string       // secret access key
+	Client    *http.Client // http client, http.DefaultClient if nil
+	Keys      KeyProvider  // keys of encrypted database, the files are uploaded as they are stored
+}
+
+// s3Client is minimal path style S3 client signing requests with AWS signature version 4
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+	now       func() time.Time
+}
+
+func newS3Client(opt *S3Options) (*s3Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(opt.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrS3Request, err.Error())
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("%w: invalid endpoint %s", ErrS3Request, opt.Endpoint)
+	}
+	sc := s3Client{
+		endpoint:  u,
+		region:    opt.Region,
+		bucket:    opt.Bucket,
+		prefix:    strings.Trim(opt.Prefix, "/"),
+		accessKey: opt.AccessKey,
+		secretKey: opt.SecretKey,
+		client:    opt.Client,
+		now:       time.Now,
+	}
+	if sc.region == "" {
+		sc.region = "us-east-1"
+	}
+	if sc.client == nil {
+		sc.client = http.DefaultClient
+	}
+	return &sc, nil
+}
+
+func (sc *s3Client) objectURL(name string) *url.URL {
+	key := name
+	if sc.prefix != "" {
+		key = sc.prefix + "/" + name
+	}
+	u := *sc.endpoint
+	u.Path = u.Path + "/" + sc.bucket + "/" + key
+	return &u
+}
+
+func (sc *s3Client) do(method, name string, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	return sc.doURL(method, sc.objectURL(name), header, body, size)
+}
+
+func (sc *s3Client) doURL(method string, u *url.URL, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	if body != nil && size == 0 {
+		body = http.NoBody
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrS3Request, err.Error())
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	signV4(req, unsignedPayload, sc.accessKey, sc.secretKey, sc.region, sc.now())
+	resp, err := sc.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrS3Request, err.Error())
+	}
+	return resp, nil
+}
+
+// head returns the object size, os.ErrNotExist is returned for the absent object
+func (sc *s3Client) head(name string) (int, error) {
+	resp, err := sc.do(http.MethodHead, name, nil, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return int(resp.ContentLength), nil
+	case http.StatusNotFound:
+		return 0, os.ErrNotExist
+	}
+	return 0, fmt.Errorf("%w: HEAD %s: %s", ErrS3Request, name, resp.Status)
+}
+
+// get reads size bytes of the object starting from position, negative size reads the whole object.
+// Server ignoring the range returns the whole object, the range is cut from it.
+func (sc *s3Client) get(name string, position, size int) ([]byte, error) {
+	header := http.Header{}
+	rng := ""
+	if size >= 0 {
+		rng = fmt.Sprintf("bytes %d-%d/", position, position+size-1)
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", position, position+size-1))
+	}
+	resp, err := sc.do(http.MethodGet, name, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusPartialContent && size >= 0:
+		if cr := resp.Header.Get("Content-Range"); !strings.HasPrefix(cr, rng) {
+			return nil, fmt.Errorf("%w: GET %s: content range %q does not match to requested %q", ErrS3Request, name, cr, rng)
+		}
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, os.ErrNotExist
+	default:
+		return nil, fmt.Errorf("%w: GET %s: %s", ErrS3Request, name, resp.Status)
+	}
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: GET %s: %s", ErrS3Request, name, err.Error())
+	}
+	if size < 0 {
+		return out, nil
+	}
+	if resp.StatusCode == http.StatusOK && len(out) >= position+size {
+		out = out[position : position+size]
+	}
+	if len(out) != size {
+		return nil, fmt.Errorf("%w: GET %s: %d bytes returned for range %q", ErrS3Request, name, len(out), rng)
+	}
+	return out, nil
+}
+
+func (sc *s3Client) put(name string, body io.Reader, size int64) error {
+	resp, err := sc.do(http.MethodPut, name, nil, body, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: PUT %s: %s", ErrS3Request, name, resp.Status)
+	}
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list returns names of all objects under the prefix
+func (sc *s3Client) list() ([]string, error) {
+	prefix := ""
+	if sc.prefix != "" {
+		prefix = sc.prefix + "/"
+	}
+	var out []string
+	token := ""
+	for {
+		u := *sc.endpoint
+		u.Path = u.Path + "/" + sc.bucket
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+		resp, err := sc.doURL(http.MethodGet, &u, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var res listBucketResult
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%w: LIST %s: %s", ErrS3Request, prefix, resp.Status)
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%w: LIST %s: %s", ErrS3Request, prefix, err.Error())
+		}
+		for _, c := range res.Contents {
+			name := strings.TrimPrefix(c.Key, prefix)
+			if name != "" && !strings.Contains(name, "/") {
+				out = append(out, name)
+			}
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return out, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+// signV4 adds AWS signature version 4 authorization to the request
+func signV4(req *http.Request, payloadHash, accessKey, secretKey, region string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	day := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := q[k]
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	return strings.ReplaceAll(strings.Join(parts, "&"), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// s3Backend is read only backend of the database published to S3
+type s3Backend struct {
+	client *s3Client
+}
+
+func (b *s3Backend) open(name string) (storage, error) {
+	return openS3Storage(b.client, name)
+}
+
+func (b *s3Backend) readFile(name string) ([]byte, error) {
+	return b.client.get(name, 0, -1)
+}
+
+func (b *s3Backend) writeFile(name string, data []byte) error {
+	return fmt.Errorf("%w: %s", ErrReadOnly, name)
+}
+
+func (b *s3Backend) remove(name string) error {
+	return fmt.Errorf("%w: %s", ErrReadOnly, name)
+}
+
+func (b *s3Backend) rename(from, to string) error {
+	return fmt.Errorf("%w: %s", ErrReadOnly, from)
+}
+
+func (b *s3Backend) list() ([]string, error) {
+	return b.client.list()
+}
+
+// s3Storage is read only storage backed by S3 object
+type s3Storage struct {
+	client *s3Client
+	name   string
+	sz     int
+}
+
+func openS3Storage(client *s3Client, name string) (*s3Storage, error) {
+	size, err := client.head(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return &s3Storage{client: client, name: name, sz: size}, nil
+}
+
+func (ss *s3Storage) size() int {
+	return ss.sz
+}
+
+func (ss *s3Storage) writer() (io.Writer, error) {
+	return nil, fmt.Errorf("%w: %s", ErrReadOnly, ss.name)
+}
+
+func (ss *s3Storage) closeWriter() error {
+	return nil
+}
+
+func (ss *s3Storage) reader(position int) (io.Reader, error) {
+	if position < 0 {
+		panic("negative file position")
+	}
+	if position >= ss.sz {
+		return nil, fmt.Errorf("%w: position is greater than storage size", ErrSeek)
+	}
+	return &s3Reader{storage: ss, position: position}, nil
+}
+
+func (ss *s3Storage) closeReader() error {
+	return nil
+}
+
+func (ss *s3Storage) readAt(p []byte, position int) (int, error) {
+	if position < 0 || position >= ss.sz {
+		return 0, io.EOF
+	}
+	size := min(len(p), ss.sz-position)
+	data, err := ss.client.get(ss.name, position, size)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, data)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (ss *s3Storage) sync() error {
+	return nil
+}
+
+func (ss *s3Storage) truncate(size int) error {
+	return fmt.Errorf("%w: %s", ErrReadOnly, ss.name)
+}
+
+func (ss *s3Storage) writeAt(p []byte, position int) error {
+	return fmt.Errorf("%w: %s", ErrReadOnly, ss.name)
+}
+
+// s3Reader issues ranged GET for every Read call, so reading the buffer of known size costs one request
+type s3Reader struct {
+	storage  *s3Storage
+	position int
+}
+
+// Read implements io.Reader
+func (sr *s3Reader) Read(p []byte) (int, error) {
+	if sr.position >= sr.storage.sz {
+		return 0, io.EOF
+	}
+	size := min(len(p), sr.storage.sz-sr.position)
+	if size == 0 {
+		return 0, nil
+	}
+	data, err := sr.storage.client.get(sr.storage.name, sr.position, size)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, data)
+	sr.position += n
+	return n, nil
+}
+
+func readS3Config(client *s3Client, name string) (*config, error) {
+	data, err := client.get(name, 0, -1)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrConfigAbsent
+		}
+		return nil, fmt.Errorf("%w: %s", ErrReadConfig, err.Error())
+	}
+	if bytes.HasPrefix(data, []byte(encMagic)) {
+		return nil, fmt.Errorf("%w: encryption key is required", ErrEncrypted)
+	}
+	var c config
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrReadConfig, err.Error())
+	}
+	return &c, nil
+}
+
+// UploadFileDb uploads file database located at path to S3, the database is expected to be closed
+func UploadFileDb(path string, opt *S3Options) error {
+	client, err := newS3Client(opt)
+	if err != nil {
+		return err
+	}
+	files, err := (&fsBackend{path: path}).list()
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := uploadFile(client, filepath.Join(path, name), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uploadFile(client *s3Client, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%w: %s %s", ErrFileRead, err.Error(), path)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return client.put(name, f, stat.Size())
+}
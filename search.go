@@ -1,6 +1,8 @@
 package vech
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"sort"
@@ -16,30 +18,183 @@ const (
 
 // Distance represents distance calculation result
 type Distance struct {
-	N        int     // index number
-	Value    float32 // vector distance value
-	Position int     // data position
-	Size     int     // data size
+	N        int               // index number
+	Value    float32           // vector distance value
+	Position int               // data position
+	Size     int               // stored data size, Data of Position and Size returns the payload
+	Data     []byte            // record data, filled if requested by SearchOptions
+	Fields   map[string][]byte // record fields, filled with data
+	Vector   []float32         // record vector, filled if requested by SearchOptions
+	Scores   *Scores           // component scores of hybrid search
 }
 
-// CosineSim calculates consine simularity over all vectors in collection
-// The results can be limited by limit value, 0 means return all
+// SearchOptions control the search, nil options are the zero value
+type SearchOptions struct {
+	Order       SortType // order of results
+	Limit       int      // maximal amount of results, 0 means all
+	WithData    bool     // read data of the results
+	WithVectors bool     // include vectors of the results
+	MMRLambda   float32  // re-ranks candidates by maximal marginal relevance when in (0, 1], 1 means relevance only
+	GroupField  string   // record field grouping results limited by MaxPerGroup, records without the field are not limited
+	MaxPerGroup int      // maximal amount of results with the same GroupField value, 0 means no limit
+	Candidates  int      // amount of the closest records diversified by MMR or group limit, 0 means 10 times Limit
+}
+
+var ErrSearchOptions = errors.New("invalid search options")
+
+// cancelCheckStep is the amount of records scanned between context cancellation checks
+const cancelCheckStep = 1024
+
+// CosineSim calculates consine simularity over all vectors in collection, collections created with
+// other metric are compared by their metric. The results can be limited by limit value, 0 means return all
 // The results are ordered by sort order
 func (c *Collection) CosineSim(vector []float32, sortOrder SortType, limit int) ([]Distance, error) {
+	return c.CosineSimContext(context.Background(), vector, sortOrder, limit)
+}
+
+// CosineSimContext is CosineSim which stops scanning and returns ctx.Err() when the context is done
+func (c *Collection) CosineSimContext(ctx context.Context, vector []float32, sortOrder SortType, limit int) ([]Distance, error) {
+	return c.Search(ctx, vector, &SearchOptions{Order: sortOrder, Limit: limit})
+}
+
+// Search compares the vector to collection vectors by the collection metric, cosine similarity by default.
+// Data of the results is read by few reads ordered by position when WithData option is set.
+func (c *Collection) Search(ctx context.Context, vector []float32, opt *SearchOptions) ([]Distance, error) {
+	if opt == nil {
+		opt = &SearchOptions{}
+	}
 	if len(vector) != c.vectorSize {
 		return nil, fmt.Errorf("%w: collection vector size: %d, provided vector size: %d", ErrVectorSize, c.vectorSize, len(vector))
 	}
-	ln := c.Len()
-	res := make([]Distance, ln)
-	for i := 0; i < ln; i++ {
-		irec, _ := c.Index(i)
-		res[i] = Distance{
-			N:        i,
-			Value:    cosineSim(vector, irec.Vector),
-			Position: irec.Position,
-			Size:     irec.Size,
+	if opt.MMRLambda < 0 || opt.MMRLambda > 1 || opt.MaxPerGroup < 0 || opt.Candidates < 0 {
+		return nil, fmt.Errorf("%w: %+v", ErrSearchOptions, *opt)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	limit := opt.Limit
+	if opt.diversified() {
+		limit = opt.Candidates
+		if limit == 0 {
+			limit = opt.Limit * 10
+		}
+	}
+	res, err := c.search(ctx, vector, opt.Order, limit)
+	if err != nil {
+		return nil, err
+	}
+	if opt.diversified() {
+		if res, err = c.diversify(res, opt); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.fillResults(res, opt.WithData, opt.WithVectors); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// search compares the vector to vectors of all segments, c.mu has to be held
+func (c *Collection) search(ctx context.Context, vector []float32, order SortType, limit int) ([]Distance, error) {
+	res := make([]Distance, 0, c.len())
+	for _, seg := range c.segments {
+		found, err := seg.search(ctx, c.metric, vector, order, limit, c.ivfProbes)
+		if err != nil {
+			return nil, err
 		}
+		res = append(res, found...)
+	}
+	sortDistances(res, order)
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
 	}
+	return res, nil
+}
+
+// fillResults reads data and vectors of the results when requested, c.mu has to be held
+func (c *Collection) fillResults(res []Distance, withData, withVectors bool) error {
+	if withVectors {
+		for i := range res {
+			seg, err := c.segmentOf(res[i].N)
+			if err != nil {
+				return err
+			}
+			res[i].Vector = seg.vector(res[i].N - seg.base)
+		}
+	}
+	if withData {
+		return c.readResultsData(res)
+	}
+	return nil
+}
+
+// coalesceGap is the largest gap between data items read by single read
+const coalesceGap = 4096
+
+// readResultsData reads data of the results in position order, data close to each other is read at once
+func (c *Collection) readResultsData(res []Distance) error {
+	var errs []error
+	err := c.readSorted(len(res), func(i int) (int, int) {
+		return res[i].Position, res[i].Size
+	}, func(i int, data []byte) {
+		seg, err := c.segmentOf(res[i].N)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		d, err := decodeData(data, seg.flags(res[i].N-seg.base), c.dictionaries)
+		res[i].Data, res[i].Fields = d.data, d.fields
+		errs = append(errs, err)
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// readSorted reads data of count items located by ref and passes it to set, reads are ordered by position
+// and data close to each other is read at once. Items without data are skipped.
+func (c *Collection) readSorted(count int, ref func(i int) (int, int), set func(i int, data []byte)) error {
+	order := make([]int, 0, count)
+	for i := range count {
+		if _, size := ref(i); size > 0 {
+			order = append(order, i)
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		pi, _ := ref(order[i])
+		pj, _ := ref(order[j])
+		return pi < pj
+	})
+	for i := 0; i < len(order); {
+		start, size := ref(order[i])
+		seg := c.dataSegment(start)
+		if seg == nil {
+			return ErrDataPosition
+		}
+		end := start + size
+		j := i + 1
+		for ; j < len(order); j++ {
+			pos, size := ref(order[j])
+			if pos > end+coalesceGap || pos+size > seg.dataBase+seg.dataSize {
+				break
+			}
+			end = max(end, pos+size)
+		}
+		buf, err := seg.data(start-seg.dataBase, end-start)
+		if err != nil {
+			return err
+		}
+		for _, k := range order[i:j] {
+			pos, size := ref(k)
+			from := pos - start
+			set(k, buf[from:from+size:from+size])
+		}
+		i = j
+	}
+	return nil
+}
+
+func sortDistances(res []Distance, sortOrder SortType) {
 	if sortOrder == SortAsc {
 		sort.Slice(res, func(i, j int) bool {
 			return res[i].Value < res[j].Value
@@ -49,11 +204,6 @@ func (c *Collection) CosineSim(vector []float32, sortOrder SortType, limit int)
 			return res[i].Value > res[j].Value
 		})
 	}
-	if limit > 0 && len(res) > limit {
-		return res[:limit], nil
-	}
-	return res, nil
-
 }
 
 // assuming the sizes are verified by caller
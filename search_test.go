@@ -1,6 +1,11 @@
 package vech
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
 
 func TestCosineSim(t *testing.T) {
 	opt := CreateDbOptions{
@@ -58,3 +63,101 @@ func TestCosineSim(t *testing.T) {
 		t.Fatal("The order is expected to be ascend")
 	}
 }
+
+func TestCosineSimContext(t *testing.T) {
+	c := newMemoryCollection(t, 8)
+	if err := addChunks(c, randomChunks(3000, 8)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	res, err := c.CosineSimContext(ctx, randomChunks(1, 8)[0].vector, SortDesc, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 10 {
+		t.Fatalf("expected 10 results, actual: %d", len(res))
+	}
+	cancel()
+	_, err = c.CosineSimContext(ctx, randomChunks(1, 8)[0].vector, SortDesc, 10)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error expected to be context.Canceled, returned: %v", err)
+	}
+}
+
+func TestSearchWithData(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 8, Path: path, SegmentSize: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomChunks(50, 8)
+	data[3].data = nil
+	if err = addChunks(c, data); err != nil {
+		t.Fatal(err)
+	}
+	for _, limit := range []int{0, 10} {
+		opt := SearchOptions{Order: SortDesc, Limit: limit, WithData: true, WithVectors: true}
+		res, err := c.Search(context.Background(), data[3].vector, &opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := c.CosineSim(data[3].vector, SortDesc, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != len(plain) {
+			t.Fatalf("expected %d results, actual: %d", len(plain), len(res))
+		}
+		for i, r := range res {
+			if r.N != plain[i].N {
+				t.Fatalf("result %d expected to be record %d, actual: %d", i, plain[i].N, r.N)
+			}
+			if !reflect.DeepEqual(r.Data, data[r.N].data) {
+				t.Fatalf("data of record %d %v does not match to original: %v", r.N, r.Data, data[r.N].data)
+			}
+			if !reflect.DeepEqual(r.Vector, data[r.N].vector) {
+				t.Fatalf("vector of record %d %v does not match to original: %v", r.N, r.Vector, data[r.N].vector)
+			}
+		}
+	}
+}
+
+func TestSearchNilOptions(t *testing.T) {
+	c := newMemoryCollection(t, 4)
+	if err := c.SetTextField("text"); err != nil {
+		t.Fatal(err)
+	}
+	data := randomChunks(20, 4)
+	if err := addChunks(c, data); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	v := data[3].vector
+	searches := map[string]func() ([]Distance, error){
+		"Search":       func() ([]Distance, error) { return c.Search(ctx, v, nil) },
+		"TextSearch":   func() ([]Distance, error) { return c.TextSearch(ctx, "foo", nil) },
+		"HybridSearch": func() ([]Distance, error) { return c.HybridSearch(ctx, v, "foo", nil) },
+		"SparseSearch": func() ([]Distance, error) {
+			return c.SparseSearch(ctx, &SparseVector{Indices: []uint32{1}, Values: []float32{1}}, nil)
+		},
+		"SearchMaxSim": func() ([]Distance, error) { return c.SearchMaxSim(ctx, [][]float32{v}, nil) },
+		"SearchNamed":  func() ([]Distance, error) { return c.SearchNamed(ctx, []VectorQuery{{Vector: v}}, nil) },
+		"Recommend":    func() ([]Distance, error) { return c.Recommend(ctx, []int{3}, nil, nil) },
+	}
+	for name, search := range searches {
+		if _, err := search(); err != nil {
+			t.Fatalf("%s with nil options returned: %v", name, err)
+		}
+	}
+	if _, err := c.SearchGroups(ctx, v, nil); !errors.Is(err, ErrSearchOptions) {
+		t.Fatalf("grouping field is required, error expected to be ErrSearchOptions, returned: %v", err)
+	}
+}
@@ -0,0 +1,653 @@
+package vech
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// segment is a part of the collection: index and data storages with optional ANN index.
+// New records are appended to the active segment, sealed segments are immutable.
+type segment struct {
+	id           int
+	sealed       bool
+	indexStorage storage
+	dataStorage  storage
+	vectorSize   int
+	encoding     Encoding
+	recordSize   int
+	dataSize     int
+	index        []byte
+	base         int // collection record number of the first segment record
+	dataBase     int // position of the segment data in the collection data space
+	ann          *ivfIndex
+	deleted      map[int]bool // tombstones of deleted records
+	text         *textIndex   // inverted index of the text field, built by text search
+	sparse       *sparseIndex // inverted index of sparse vectors, built by sparse search
+	named        *namedIndex  // named vectors loaded by named vector search
+	hidden       bool         // storages hold interrupted write which is not rolled back, so nothing is appended
+	pins         int          // users of the segment outside the collection lock, guarded by Collection.pinMu
+	retired      bool         // the segment is replaced, it is dropped by its last user
+	remove       bool         // files of the retired segment are removed when it is dropped
+}
+
+// segmentExts are extensions of segment files, journals are kept by encrypted storages while they are written
+var segmentExts = []string{".idx", ".data", ".ivf", ".del", batchExt, ".idx" + journalExt, ".data" + journalExt}
+
+// batchExt is the extension of the marker written while batch is appended to the segment
+const batchExt = ".batch"
+
+// batchMarker keeps segment storage sizes before the batch, so interrupted batch is rolled back
+type batchMarker struct {
+	IndexSize int
+	DataSize  int
+}
+
+// manifest lists collection segments, the last one is active
+type manifest struct {
+	Segments     []int
+	Codec        Codec              // compression of added records
+	Level        int                // compression level
+	Dictionaries [][]byte           // compression dictionaries, the last one is used by compression
+	Options      *CollectionOptions // collection settings, nil means settings of the database config
+}
+
+// options returns settings of the collection, collections created before the settings were kept
+// in the manifest use the database config
+func (m *manifest) options(cfg *config) *CollectionOptions {
+	if m.Options != nil {
+		return m.Options
+	}
+	return defaultOptions(cfg)
+}
+
+// defaultOptions returns collection settings of the database config
+func defaultOptions(cfg *config) *CollectionOptions {
+	return &CollectionOptions{
+		VectorSize: cfg.VectorSize,
+		IndexType:  cfg.IndexType,
+		IVFLists:   cfg.IVFLists,
+		IVFProbes:  cfg.IVFProbes,
+	}
+}
+
+// segmentName returns the base name of segment files, the first segment uses collection name
+// so collections created before segmentation are opened as single segment
+func segmentName(collection string, id int) string {
+	if id == 0 {
+		return collection
+	}
+	return fmt.Sprintf("%s.%d", collection, id)
+}
+
+func readManifest(b backend, name string) (*manifest, error) {
+	data, err := b.readFile(name + ".manifest")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &manifest{Segments: []int{0}}, nil
+		}
+		return nil, err
+	}
+	var m manifest
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptedDb, err.Error())
+	}
+	if len(m.Segments) == 0 {
+		return nil, fmt.Errorf("%w: empty manifest %s", ErrCorruptedDb, name)
+	}
+	return &m, nil
+}
+
+func writeManifest(b backend, name string, m *manifest) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+		return err
+	}
+	return b.writeFile(name+".manifest", buf.Bytes())
+}
+
+// collectionFile reports whether the database file belongs to the collection with the segments,
+// files of not listed segments are not recognized
+func collectionFile(file, collection string, segments []int) bool {
+	rest, ok := strings.CutPrefix(file, collection+".")
+	if !ok {
+		return false
+	}
+	if rest == "manifest" || slices.Contains(segmentExts, "."+rest) {
+		return true
+	}
+	if id, ext, ok := strings.Cut(rest, "."); ok {
+		n, err := strconv.Atoi(id)
+		return err == nil && strconv.Itoa(n) == id && n > 0 && slices.Contains(segments, n) && slices.Contains(segmentExts, "."+ext)
+	}
+	return false
+}
+
+// openSegment opens the segment, recovers rolls back writes interrupted by crash. Only the holder
+// of the exclusive lock recovers, other instances hide the interrupted write, since it may be still written.
+func openSegment(b backend, collection string, id, vectorSize int, enc Encoding, recovers bool) (*segment, error) {
+	name := segmentName(collection, id)
+	idx, err := b.open(name + ".idx")
+	if err != nil {
+		return nil, err
+	}
+	dt, err := b.open(name + ".data")
+	if err != nil {
+		return nil, err
+	}
+	// partially written trailing record of concurrently appending writer is ignored
+	recordSize := vectorSize*enc.size() + 16
+	idxSize := idx.size() / recordSize * recordSize
+	s := segment{
+		id:           id,
+		indexStorage: idx,
+		dataStorage:  dt,
+		vectorSize:   vectorSize,
+		encoding:     enc,
+		recordSize:   recordSize,
+		dataSize:     dt.size(),
+		index:        make([]byte, idxSize),
+		deleted:      make(map[int]bool),
+		text:         newTextIndex(),
+		sparse:       newSparseIndex(),
+		named:        &namedIndex{},
+	}
+	if err := s.loadDeleted(b, name); err != nil {
+		return nil, err
+	}
+	if idxSize > 0 {
+		reader, err := idx.reader(0)
+		if err != nil {
+			return nil, err
+		}
+		defer idx.closeReader()
+		if _, err := io.ReadFull(reader, s.index); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, ErrCorruptedDb
+			}
+			return nil, err
+		}
+	}
+	if err := s.recoverBatch(b, collection, recovers); err != nil {
+		return nil, err
+	}
+	if s.hidden {
+		return &s, nil
+	}
+	if err := s.trimTail(recovers); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// trimTail drops the trailing record of the add interrupted by crash, so the next add does not follow
+// the partial record. Without recovers the record is just hidden, since the writer may be appending it.
+func (s *segment) trimTail(recovers bool) error {
+	dataEnd := 0
+	if n := s.len(); n > 0 {
+		pos, size := s.entry(n - 1)
+		dataEnd = pos + size
+	}
+	if s.indexStorage.size() <= len(s.index) && s.dataSize <= dataEnd {
+		return nil
+	}
+	if !recovers {
+		s.dataSize = min(s.dataSize, dataEnd)
+		s.hidden = true
+		return nil
+	}
+	if s.indexStorage.size() > len(s.index) {
+		if err := s.indexStorage.truncate(len(s.index)); err != nil {
+			return err
+		}
+	}
+	if s.dataSize > dataEnd {
+		if err := s.dataStorage.truncate(dataEnd); err != nil {
+			return err
+		}
+		s.dataSize = dataEnd
+	}
+	return nil
+}
+
+// removeSegment deletes all segment files
+func removeSegment(b backend, collection string, id int) error {
+	name := segmentName(collection, id)
+	var errs []error
+	for _, ext := range segmentExts {
+		if err := b.remove(name + ext); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *segment) len() int {
+	return len(s.index) / s.recordSize
+}
+
+// size returns the amount of bytes occupied by the segment
+func (s *segment) size() int {
+	return len(s.index) + s.dataSize
+}
+
+// live returns the amount of not deleted records
+func (s *segment) live() int {
+	return s.len() - len(s.deleted)
+}
+
+func (s *segment) loadDeleted(b backend, name string) error {
+	data, err := b.readFile(name + ".del")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var deleted []int
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&deleted); err != nil {
+		return fmt.Errorf("%w: %s", ErrCorruptedDb, err.Error())
+	}
+	for _, n := range deleted {
+		s.deleted[n] = true
+	}
+	return nil
+}
+
+func (s *segment) saveDeleted(b backend, collection string) error {
+	data, err := encodeDeleted(s.deleted)
+	if err != nil {
+		return err
+	}
+	return b.writeFile(segmentName(collection, s.id)+".del", data)
+}
+
+func encodeDeleted(deleted map[int]bool) ([]byte, error) {
+	list := make([]int, 0, len(deleted))
+	for n := range deleted {
+		list = append(list, n)
+	}
+	sort.Ints(list)
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(list); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// add appends the record, flags describe the encoding of the data
+func (s *segment) add(vector []float32, data []byte, flags int) error {
+	if s.hidden {
+		return ErrNotRecovered
+	}
+	idxWriter, err := s.indexStorage.writer()
+	if err != nil {
+		return err
+	}
+	dataWriter, err := s.dataStorage.writer()
+	if err != nil {
+		return err
+	}
+	ln := len(s.index)
+	s.index = appendRecord(s.index, s.dataSize, len(data), flags, s.encoding, vector)
+	// data is written first, so the index never refers to absent data
+	if _, err = dataWriter.Write(data); err != nil {
+		return err
+	}
+	if _, err = idxWriter.Write(s.index[ln:]); err != nil {
+		return err
+	}
+	s.dataSize += len(data)
+	return nil
+}
+
+// appendRecord appends encoded index record to the index, flags describe the encoding of the data
+func appendRecord(index []byte, pos, size, flags int, enc Encoding, vector []float32) []byte {
+	var head [16]byte
+	intToBytes(pos, head[:])
+	intToBytes(size|flags, head[8:])
+	index = append(index, head[:]...)
+	return enc.append(index, vector)
+}
+
+// addBatch appends encoded index records and their data, record positions are relative to the batch data.
+// The batch marker is kept while storages are written, so the batch interrupted by crash is rolled back
+// by the next open. Durable batch is synced to stable storage before the marker is removed.
+func (s *segment) addBatch(b backend, collection string, index, data []byte, durable bool) error {
+	if s.hidden {
+		return ErrNotRecovered
+	}
+	idxWriter, err := s.indexStorage.writer()
+	if err != nil {
+		return err
+	}
+	dataWriter, err := s.dataStorage.writer()
+	if err != nil {
+		return err
+	}
+	index = slices.Clone(index) // positions are rebased, the batch of the caller is kept for retry
+	for p := 0; p < len(index); p += s.recordSize {
+		intToBytes(bytesToInt(index[p:])+s.dataSize, index[p:])
+	}
+	marker := segmentName(collection, s.id) + batchExt
+	before := batchMarker{IndexSize: len(s.index), DataSize: s.dataSize}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(before); err != nil {
+		return err
+	}
+	if err := b.writeFile(marker, buf.Bytes()); err != nil {
+		return err
+	}
+	err = func() error {
+		if _, err := dataWriter.Write(data); err != nil {
+			return err
+		}
+		if _, err := idxWriter.Write(index); err != nil {
+			return err
+		}
+		if durable {
+			if err := s.dataStorage.sync(); err != nil {
+				return err
+			}
+			if err := s.indexStorage.sync(); err != nil {
+				return err
+			}
+		}
+		return b.remove(marker)
+	}()
+	if err != nil {
+		// the marker is kept if rollback fails, so the batch is rolled back by the next open
+		if rerr := s.rollback(before); rerr != nil {
+			return errors.Join(err, rerr)
+		}
+		return errors.Join(err, b.remove(marker))
+	}
+	s.index = append(s.index, index...)
+	s.dataSize += len(data)
+	return nil
+}
+
+// rollback truncates segment storages to the sizes before the batch
+func (s *segment) rollback(m batchMarker) error {
+	if err := s.indexStorage.truncate(m.IndexSize); err != nil {
+		return err
+	}
+	return s.dataStorage.truncate(m.DataSize)
+}
+
+// readBatch returns the marker of the batch being written or interrupted by crash, nil if there is none
+func (s *segment) readBatch(b backend, collection string) (*batchMarker, error) {
+	data, err := b.readFile(segmentName(collection, s.id) + batchExt)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var m batchMarker
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptedDb, err.Error())
+	}
+	return &m, nil
+}
+
+// hideBatch excludes records of the batch from the segment
+func (s *segment) hideBatch(m *batchMarker) {
+	s.index = s.index[:min(len(s.index), m.IndexSize)]
+	s.dataSize = min(s.dataSize, m.DataSize)
+}
+
+// recoverBatch rolls back the batch interrupted by crash. Without recovers the batch is just hidden,
+// since it may be still written by the writer process.
+func (s *segment) recoverBatch(b backend, collection string, recovers bool) error {
+	m, err := s.readBatch(b, collection)
+	if err != nil || m == nil {
+		return err
+	}
+	if !recovers {
+		s.hideBatch(m)
+		s.hidden = true
+		return nil
+	}
+	if err := s.rollback(*m); err != nil {
+		return err
+	}
+	if err := b.remove(segmentName(collection, s.id) + batchExt); err != nil {
+		return err
+	}
+	s.hideBatch(m)
+	return nil
+}
+
+// refresh reads records and tombstones added by another process
+func (s *segment) refresh(b backend, collection string) error {
+	tail := (s.indexStorage.size() - len(s.index)) / s.recordSize * s.recordSize
+	if tail > 0 {
+		buf := make([]byte, tail)
+		n, err := s.indexStorage.readAt(buf, len(s.index))
+		if n != tail {
+			if err == nil {
+				err = ErrCorruptedDb
+			}
+			return err
+		}
+		s.index = append(s.index, buf...)
+	}
+	// storages and tombstones only grow, files removed by merge of the writer do not shrink them
+	s.dataSize = max(s.dataSize, s.dataStorage.size())
+	if err := s.loadDeleted(b, segmentName(collection, s.id)); err != nil {
+		return err
+	}
+	// records of the batch being written become visible when the batch is complete,
+	// the batch is never rolled back here since the writer is still writing it
+	m, err := s.readBatch(b, collection)
+	if err != nil || m == nil {
+		return err
+	}
+	s.hideBatch(m)
+	return nil
+}
+
+// record returns index record with the position local to the segment data
+func (s *segment) record(n int) (*IndexRecord, error) {
+	if n < 0 {
+		return nil, ErrIndexOutOfRange
+	}
+	if s.deleted[n] {
+		return nil, ErrDeleted
+	}
+	var ret IndexRecord
+
+	start := s.recordSize * n
+	end := start + 16
+	if end > len(s.index) {
+		return nil, ErrIndexOutOfRange
+	}
+	ret.Position, ret.Size, _ = decodeEntry(s.index[start:end])
+
+	if start+s.recordSize > len(s.index) {
+		return nil, ErrIndexOutOfRange
+	}
+	ret.Vector = s.vector(n)
+	return &ret, nil
+}
+
+// entry returns local data position and size of the record
+func (s *segment) entry(n int) (int, int) {
+	start := s.recordSize * n
+	pos, size, _ := decodeEntry(s.index[start : start+16])
+	return pos, size
+}
+
+// recordAt returns the local number of the record with data at the position of the size, -1 if there is none.
+// Records are appended in data order, so the positions are ascending.
+func (s *segment) recordAt(pos, size int) int {
+	n := sort.Search(s.len(), func(i int) bool {
+		p, _ := s.entry(i)
+		return p >= pos
+	})
+	for ; n < s.len(); n++ {
+		p, sz := s.entry(n)
+		if p != pos {
+			break
+		}
+		if sz == size {
+			return n
+		}
+	}
+	return -1
+}
+
+// flags returns the flags describing the encoding of the record data
+func (s *segment) flags(n int) int {
+	start := s.recordSize * n
+	_, _, flags := decodeEntry(s.index[start : start+16])
+	return flags
+}
+
+// flags of the record data kept in the top byte of the index size value
+const (
+	fieldsFlag = 1 << 56 // data is encoded record fields
+	flateFlag  = 1 << 57 // data is compressed by DEFLATE
+	multiFlag  = 1 << 58 // data starts with token vectors of multi-vector record
+	sparseFlag = 1 << 59 // data contains sparse vector, it follows token vectors
+	namedFlag  = 1 << 60 // data contains named vectors, they follow sparse vector
+	sizeMask   = 1<<56 - 1
+)
+
+// decodeEntry decodes data position, size and flags of the index record header.
+// The flags are kept in the top byte of the size value.
+func decodeEntry(head []byte) (int, int, int) {
+	size := bytesToInt(head[8:16])
+	return bytesToInt(head[:8]), size & sizeMask, size &^ sizeMask
+}
+
+// vector returns the vector of the record, float32 vectors share memory with the index
+func (s *segment) vector(n int) []float32 {
+	start := s.recordSize*n + 16
+	return s.encoding.decode(s.index[start : s.recordSize*(n+1)])
+}
+
+// data reads the segment data, position is local to the segment
+func (s *segment) data(pos, size int) ([]byte, error) {
+	if pos < 0 || size <= 0 || pos+size > s.dataSize {
+		return nil, ErrDataPosition
+	}
+	return readStorage(s.dataStorage, pos, size)
+}
+
+// readStorage reads size bytes at position, it is safe for concurrent use
+func readStorage(st storage, pos, size int) ([]byte, error) {
+	out := make([]byte, size)
+	cnt, err := st.readAt(out, pos)
+	if cnt != size {
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return nil, ErrReadData
+	}
+	return out, nil
+}
+
+// search compares segment vectors to the vector by the metric, ANN index is used for the closest results only
+func (s *segment) search(ctx context.Context, metric Metric, vector []float32, sortOrder SortType, limit, nprobes int) ([]Distance, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var res []Distance
+	if s.ann != nil && sortOrder == metric.closest() && limit > 0 {
+		for i, n := range s.ann.candidates(vector, nprobes) {
+			if i%cancelCheckStep == cancelCheckStep-1 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+			}
+			if !s.deleted[n] {
+				res = append(res, s.distance(metric, vector, n))
+			}
+		}
+	} else {
+		ln := s.len()
+		res = make([]Distance, 0, ln)
+		for i := 0; i < ln; i++ {
+			if i%cancelCheckStep == cancelCheckStep-1 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+			}
+			if !s.deleted[i] {
+				res = append(res, s.distance(metric, vector, i))
+			}
+		}
+	}
+	sortDistances(res, sortOrder)
+	if limit > 0 && len(res) > limit {
+		return res[:limit], nil
+	}
+	return res, nil
+}
+
+// distance returns the distance with record number and position converted to collection space
+func (s *segment) distance(metric Metric, vector []float32, n int) Distance {
+	pos, size := s.entry(n)
+	return Distance{
+		N:        s.base + n,
+		Value:    metric.distance(vector, s.vector(n)),
+		Position: s.dataBase + pos,
+		Size:     size,
+	}
+}
+
+// buildANN builds the ANN index of the sealed segment and saves it next to segment files
+func (s *segment) buildANN(b backend, collection string, lists int, metric Metric) error {
+	s.ann = buildIVF(s.vector, s.len(), lists, metric)
+	if s.ann == nil {
+		return nil
+	}
+	data, err := encodeIVF(s.ann)
+	if err != nil {
+		return err
+	}
+	return b.writeFile(segmentName(collection, s.id)+".ivf", data)
+}
+
+// loadANN reads previously built ANN index, false is returned if it does not exist
+func (s *segment) loadANN(b backend, collection string) (bool, error) {
+	data, err := b.readFile(segmentName(collection, s.id) + ".ivf")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	s.ann, err = decodeIVF(data)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *segment) close() error {
+	var errs []error
+	if err := s.indexStorage.closeReader(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.indexStorage.closeWriter(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.dataStorage.closeReader(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.dataStorage.closeWriter(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
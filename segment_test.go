@@ -0,0 +1,149 @@
+package vech
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func randomChunks(n, vectorSize int) []testdata {
+	rnd := rand.New(rand.NewSource(1))
+	out := make([]testdata, n)
+	for i := range out {
+		v := make([]float32, vectorSize)
+		for j := range v {
+			v[j] = rnd.Float32()*2 - 1
+		}
+		out[i] = testdata{vector: v, data: []byte{byte(i), byte(i >> 8), 1}}
+	}
+	return out
+}
+
+func checkChunks(t *testing.T, c *Collection, chunks []testdata) {
+	t.Helper()
+	if c.Len() != len(chunks) {
+		t.Fatalf("collection length expected to be %d, actual: %d", len(chunks), c.Len())
+	}
+	for n, d := range chunks {
+		idxrec, err := c.Index(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(d.vector, idxrec.Vector) {
+			t.Fatalf("vector %d read %v does not match to original: %v", n, idxrec.Vector, d.vector)
+		}
+		data, err := c.Data(idxrec.Position, idxrec.Size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(d.data, data) {
+			t.Fatalf("data %d read %v does not match to original: %v", n, data, d.data)
+		}
+	}
+}
+
+func TestSegmentedCollection(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := CreateDbOptions{
+		VectorSize:  4,
+		StorageType: FileSystem,
+		Path:        path,
+		SegmentSize: 64, // two records per segment
+	}
+	db, err := CreateDb(&opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = addChunks(c, chunks); err != nil {
+		c.Close()
+		t.Fatal(err)
+	}
+	if c.Segments() != 3 {
+		t.Fatalf("segments amount expected to be 3, actual: %d", c.Segments())
+	}
+	checkChunks(t, c, chunks)
+	expected, err := c.CosineSim(chunks[1].vector, SortDesc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected[0].N != 1 {
+		t.Fatalf("the best match expected to be 1, actual: %d", expected[0].N)
+	}
+	c.Close()
+
+	c, err = db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Segments() != 3 {
+		t.Fatalf("segments amount expected to be 3, actual: %d", c.Segments())
+	}
+	checkChunks(t, c, chunks)
+	dist, err := c.CosineSim(chunks[1].vector, SortDesc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, dist) {
+		t.Fatalf("search result after reopen %v does not match to original: %v", dist, expected)
+	}
+}
+
+func TestSegmentIVF(t *testing.T) {
+	opt := CreateDbOptions{
+		VectorSize:  8,
+		StorageType: Memory,
+		SegmentSize: 100 * (8*4 + 16 + 3),
+		IndexType:   IVFIndex,
+		IVFLists:    4,
+		IVFProbes:   4,
+	}
+	db, err := CreateDb(&opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomChunks(250, 8)
+	if err = addChunks(c, data); err != nil {
+		t.Fatal(err)
+	}
+	if c.Segments() != 3 {
+		t.Fatalf("segments amount expected to be 3, actual: %d", c.Segments())
+	}
+	for i, s := range c.segments {
+		if s.sealed != (s.ann != nil) {
+			t.Fatalf("segment %d is expected to have ANN index only when sealed", i)
+		}
+	}
+	// probing all clusters gives exact result
+	dist, err := c.CosineSim(data[7].vector, SortDesc, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := c.CosineSim(data[7].vector, SortDesc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(all[:10], dist) {
+		t.Fatalf("ANN result %v does not match to exact: %v", dist, all[:10])
+	}
+
+	c, err = db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.segments[0].ann == nil {
+		t.Fatal("ANN index expected to be loaded")
+	}
+	checkChunks(t, c, data)
+}
@@ -0,0 +1,221 @@
+package vech
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var ErrDirNotEmpty = errors.New("directory is not empty")
+
+// Snapshot writes point-in-time copy of the database into the empty directory dir.
+// Collections opened by the instance are copied consistently while writers keep appending:
+// sealed segments are hard linked when possible and append-only files are copied up to
+// the length recorded when the snapshot started. Other collections are copied as they are.
+// Snapshot of the memory database is the file database.
+func (db *Db) Snapshot(dir string) error {
+	dir = strings.TrimSuffix(dir, "/")
+	if err := checkEmptyDir(dir); err != nil {
+		return err
+	}
+	var dst backend = &fsBackend{path: dir}
+	var err error
+	if db.keys != nil {
+		if dst, err = newEncBackend(dst, db.keys); err != nil {
+			return err
+		}
+		err = writeConfigFile(dst, db.config)
+	} else {
+		err = saveConfig(dir+"/vech.cfg", db.config)
+	}
+	if err != nil {
+		return err
+	}
+	open := db.openCollections()
+	f, err := db.listFiles()
+	if err != nil {
+		return err
+	}
+	owned := make(map[string]bool)
+	for name := range open {
+		files, err := f.of(name)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			owned[file] = true
+		}
+	}
+	for _, c := range open {
+		if err := c.snapshot(db.storageType == FileSystem, db.path, dir, dst); err != nil {
+			return err
+		}
+	}
+	for _, file := range f.files {
+		// storages are copied without appends of their journals, so journals are not copied
+		if owned[file] || file == "vech.cfg" || strings.HasSuffix(file, journalExt) {
+			continue
+		}
+		if err := copyFile(db.backend, file, -1, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshot copies the collection state at the moment of the call, link enables hard links of sealed segments
+func (c *Collection) snapshot(link bool, srcPath, dstPath string, dst backend) error {
+	type segmentState struct {
+		id      int
+		sealed  bool
+		sizes   map[string]int
+		ann     *ivfIndex
+		deleted []byte
+	}
+	// merges remove sealed segments, so the segments are pinned until copy is done
+	c.mu.RLock()
+	segments := slices.Clone(c.segments)
+	c.pin(segments)
+	defer c.unpin(segments)
+	m := c.manifest()
+	states := make([]segmentState, len(c.segments))
+	for i, s := range c.segments {
+		states[i] = segmentState{
+			id:     s.id,
+			sealed: s.sealed,
+			sizes:  map[string]int{".idx": len(s.index), ".data": s.dataSize},
+			ann:    s.ann,
+		}
+		if len(s.deleted) > 0 {
+			data, err := encodeDeleted(s.deleted)
+			if err != nil {
+				c.mu.RUnlock()
+				return err
+			}
+			states[i].deleted = data
+		}
+	}
+	c.mu.RUnlock()
+
+	for _, st := range states {
+		name := segmentName(c.name, st.id)
+		for ext, size := range st.sizes {
+			if link && st.sealed && os.Link(srcPath+"/"+name+ext, dstPath+"/"+name+ext) == nil {
+				continue
+			}
+			if err := copyFile(c.backend, name+ext, size, dst); err != nil {
+				return err
+			}
+		}
+		if st.ann != nil {
+			data, err := encodeIVF(st.ann)
+			if err != nil {
+				return err
+			}
+			if err := dst.writeFile(name+".ivf", data); err != nil {
+				return err
+			}
+		}
+		if st.deleted != nil {
+			if err := dst.writeFile(name+".del", st.deleted); err != nil {
+				return err
+			}
+		}
+	}
+	return writeManifest(dst, c.name, m)
+}
+
+// isStorageFile reports whether the file is append-only storage, other files are small metadata files
+func isStorageFile(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".idx" || ext == ".data"
+}
+
+// copyFile copies size bytes of the file to dst, negative size copies the whole file
+func copyFile(src backend, name string, size int, dst backend) error {
+	if !isStorageFile(name) {
+		data, err := src.readFile(name)
+		if err != nil {
+			return err
+		}
+		return dst.writeFile(name, data)
+	}
+	return copyStorage(src, name, size, dst, name)
+}
+
+// copyStorage copies size bytes of the storage to dst storage dstName, negative size copies the whole storage
+func copyStorage(src backend, name string, size int, dst backend, dstName string) error {
+	st, err := src.open(name)
+	if err != nil {
+		return err
+	}
+	defer st.closeReader()
+	if size < 0 {
+		size = st.size()
+	}
+	out, err := dst.open(dstName)
+	if err != nil {
+		return err
+	}
+	err = func() error {
+		if size == 0 {
+			return nil
+		}
+		w, err := out.writer()
+		if err != nil {
+			return err
+		}
+		reader, err := st.reader(0)
+		if err != nil {
+			return err
+		}
+		if _, err = io.CopyN(w, reader, int64(size)); err != nil {
+			return err
+		}
+		return out.sync()
+	}()
+	return errors.Join(err, out.closeWriter(), out.closeReader())
+}
+
+// Restore creates file database at path from the snapshot directory
+func Restore(snapshot, path string) error {
+	snapshot = strings.TrimSuffix(snapshot, "/")
+	path = strings.TrimSuffix(path, "/")
+	if _, err := readConfig(snapshot + "/vech.cfg"); err != nil && !errors.Is(err, ErrEncrypted) {
+		return err
+	}
+	if err := checkEmptyDir(path); err != nil {
+		return err
+	}
+	src := &fsBackend{path: snapshot}
+	dst := &fsBackend{path: path}
+	files, err := src.list()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := copyFile(src, file, -1, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkEmptyDir creates the directory if it does not exist and checks it is empty
+func checkEmptyDir(path string) error {
+	if err := checkOrCreateDir(path); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%w: %s", ErrDirNotEmpty, path)
+	}
+	return nil
+}
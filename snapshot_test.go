@@ -0,0 +1,148 @@
+package vech
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := setupDir("testdb-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := setupDir("testdb-restored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDir("testdb-snapshot")
+	defer removeDir("testdb-restored")
+	opt := CreateDbOptions{
+		VectorSize:  4,
+		StorageType: FileSystem,
+		Path:        path,
+		SegmentSize: 4 * (4*4 + 16 + 3),
+	}
+	db, err := CreateDb(&opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar, err := db.OpenCollection("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = addChunks(bar, chunks); err != nil {
+		t.Fatal(err)
+	}
+	bar.Close()
+
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	data := randomChunks(300, 4)
+	if err = addChunks(c, data[:10]); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan error)
+	go func() {
+		written <- addChunks(c, data[10:])
+	}()
+	if err = db.Snapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-written; err != nil {
+		t.Fatal(err)
+	}
+	err = db.Snapshot(snap)
+	if err == nil || !errors.Is(err, ErrDirNotEmpty) {
+		t.Fatalf("error expected to be ErrDirNotEmpty, returned: %v", err)
+	}
+
+	if err = Restore(snap, restored); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{snap, restored} {
+		sdb, err := OpenFileDb(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc, err := sdb.OpenCollection("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := sc.Len()
+		if n < 10 || n > len(data) {
+			t.Fatalf("snapshot length expected to be within 10-%d, actual: %d", len(data), n)
+		}
+		if _, err = sc.Index(2); !errors.Is(err, ErrDeleted) {
+			t.Fatalf("error expected to be ErrDeleted, returned: %v", err)
+		}
+		for i := 0; i < n; i++ {
+			if i == 2 {
+				continue
+			}
+			rec, err := sc.Index(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := sc.Data(rec.Position, rec.Size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(data[i].vector, rec.Vector) || !reflect.DeepEqual(data[i].data, d) {
+				t.Fatalf("record %d of snapshot does not match to original", i)
+			}
+		}
+		sc.Close()
+		sb, err := sdb.OpenCollection("bar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkChunks(t, sb, chunks)
+		sb.Close()
+	}
+}
+
+func TestSnapshotMemory(t *testing.T) {
+	snap, err := setupDir("testdb-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDir("testdb-snapshot")
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, StorageType: Memory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = addChunks(c, chunks); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Snapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	sdb, err := OpenFileDb(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close()
+	if sdb.config.VectorSize != 4 {
+		t.Fatalf("vector size expected to be 4, actual: %d", sdb.config.VectorSize)
+	}
+	sc, err := sdb.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, sc, chunks)
+}
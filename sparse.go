@@ -0,0 +1,307 @@
+package vech
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Sparse vectors
+//
+// The record may carry sparse vector of weighted dimensions, e.g. term weights of SPLADE-style retrievers.
+// The vector is kept in the record data after token vectors, prefixed by uvarint byte length: uvarint count
+// of the entries followed by uvarint delta of the dimension index and little endian float32 weight of every entry.
+// Sparse search scores records by dot product with inverted index kept in memory, it is built per segment
+// by the first sparse search like the text index. Posting lists are pruned by MaxScore, so lists of the query
+// dimensions which can not lift the record into the results are only probed for records found by other lists.
+
+var ErrSparseVector = errors.New("invalid sparse vector")
+
+// SparseVector is the vector of nonzero dimensions given by their indices and values
+type SparseVector struct {
+	Indices []uint32
+	Values  []float32
+}
+
+// sorted returns the copy of the vector ordered by indices, duplicate indices are invalid
+func (v *SparseVector) sorted() (*SparseVector, error) {
+	if len(v.Indices) != len(v.Values) {
+		return nil, fmt.Errorf("%w: %d indices, %d values", ErrSparseVector, len(v.Indices), len(v.Values))
+	}
+	order := make([]int, len(v.Indices))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return cmp.Compare(v.Indices[a], v.Indices[b])
+	})
+	out := &SparseVector{Indices: make([]uint32, len(order)), Values: make([]float32, len(order))}
+	for i, k := range order {
+		if i > 0 && v.Indices[k] == out.Indices[i-1] {
+			return nil, fmt.Errorf("%w: duplicate index %d", ErrSparseVector, v.Indices[k])
+		}
+		out.Indices[i], out.Values[i] = v.Indices[k], v.Values[k]
+	}
+	return out, nil
+}
+
+// encodeSparse returns encoded sparse vector prefixed by its length
+func encodeSparse(v *SparseVector) ([]byte, error) {
+	s, err := v.sorted()
+	if err != nil {
+		return nil, err
+	}
+	body := binary.AppendUvarint(nil, uint64(len(s.Indices)))
+	prev := uint32(0)
+	for i, index := range s.Indices {
+		body = binary.AppendUvarint(body, uint64(index-prev))
+		body = binary.LittleEndian.AppendUint32(body, math.Float32bits(s.Values[i]))
+		prev = index
+	}
+	return append(binary.AppendUvarint(nil, uint64(len(body))), body...), nil
+}
+
+// decodeSparse decodes the sparse vector, nil is returned for empty data
+func decodeSparse(data []byte) (*SparseVector, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, fmt.Errorf("%w: invalid sparse vector", ErrCorruptedDb)
+	}
+	v := &SparseVector{Indices: make([]uint32, count), Values: make([]float32, count)}
+	pos, index := n, uint64(0)
+	for i := range v.Indices {
+		delta, n := binary.Uvarint(data[pos:])
+		if n <= 0 || pos+n+4 > len(data) {
+			return nil, fmt.Errorf("%w: invalid sparse vector", ErrCorruptedDb)
+		}
+		index += delta
+		v.Indices[i] = uint32(index)
+		v.Values[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[pos+n:]))
+		pos += n + 4
+	}
+	return v, nil
+}
+
+type sparsePosting struct {
+	n     int // local record number
+	value float32
+}
+
+// sparseIndex is inverted index of sparse vectors of segment records
+type sparseIndex struct {
+	mu       sync.Mutex // guards indexing by concurrent searches
+	postings map[uint32][]sparsePosting
+	bounds   map[uint32][2]float32 // minimal and maximal value of the postings
+	indexed  int                   // amount of indexed records
+}
+
+func newSparseIndex() *sparseIndex {
+	return &sparseIndex{postings: make(map[uint32][]sparsePosting), bounds: make(map[uint32][2]float32)}
+}
+
+// indexSparse indexes segment records added since the previous call, c.mu has to be held
+func (c *Collection) indexSparse(seg *segment) error {
+	ix := seg.sparse
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	from := ix.indexed
+	count := seg.len() - from
+	vectors := make([]*SparseVector, count)
+	var errs []error
+	err := c.readSorted(count, func(i int) (int, int) {
+		n := from + i
+		pos, size := seg.entry(n)
+		if seg.deleted[n] || seg.flags(n)&sparseFlag == 0 {
+			return seg.dataBase + pos, 0
+		}
+		return seg.dataBase + pos, size
+	}, func(i int, data []byte) {
+		d, err := decodeData(data, seg.flags(from+i), c.dictionaries)
+		if err == nil {
+			vectors[i], err = decodeSparse(d.sparse)
+		}
+		errs = append(errs, err)
+	})
+	if err != nil {
+		return err
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	// postings are appended in record order, so the lists stay ordered by record number
+	for i, v := range vectors {
+		if v == nil {
+			continue
+		}
+		for k, index := range v.Indices {
+			value := v.Values[k]
+			b, ok := ix.bounds[index]
+			if !ok {
+				b = [2]float32{value, value}
+			}
+			ix.bounds[index] = [2]float32{min(b[0], value), max(b[1], value)}
+			ix.postings[index] = append(ix.postings[index], sparsePosting{n: from + i, value: value})
+		}
+	}
+	ix.indexed = seg.len()
+	return nil
+}
+
+// SparseSearch returns records with sparse vectors ordered by descending dot product with the query.
+// Records without common dimensions are not returned. Order and diversification options are ignored.
+func (c *Collection) SparseSearch(ctx context.Context, query *SparseVector, opt *SearchOptions) ([]Distance, error) {
+	if opt == nil {
+		opt = &SearchOptions{}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	res, err := c.sparseSearch(ctx, query, opt.Limit)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.fillResults(res, opt.WithData, opt.WithVectors); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// sparseSearch returns the best limit records of every segment merged, c.mu has to be held
+func (c *Collection) sparseSearch(ctx context.Context, query *SparseVector, limit int) ([]Distance, error) {
+	q, err := query.sorted()
+	if err != nil {
+		return nil, err
+	}
+	var res []Distance
+	for _, seg := range c.segments {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := c.indexSparse(seg); err != nil {
+			return nil, err
+		}
+		// indexing is complete, so the index is not modified while the collection lock is held
+		for _, d := range seg.sparse.search(q, seg.deleted, limit) {
+			pos, size := seg.entry(d.N)
+			res = append(res, Distance{N: seg.base + d.N, Value: d.Value, Position: seg.dataBase + pos, Size: size})
+		}
+	}
+	sortByScore(res)
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// sparseTerm is the posting list of the query dimension with its cursor
+type sparseTerm struct {
+	postings []sparsePosting
+	weight   float64
+	bound    float64 // upper bound of the contribution to the score
+	cur      int
+}
+
+// search returns the best limit records by MaxScore, all matching records if limit is 0.
+// The terms are ordered by bound, the shortest prefix of terms with total bound below the threshold
+// of the results is not essential: records found only in these lists can not be included.
+func (ix *sparseIndex) search(q *SparseVector, deleted map[int]bool, limit int) []Distance {
+	var terms []*sparseTerm
+	for i, index := range q.Indices {
+		postings := ix.postings[index]
+		if len(postings) == 0 {
+			continue
+		}
+		w, b := float64(q.Values[i]), ix.bounds[index]
+		terms = append(terms, &sparseTerm{
+			postings: postings,
+			weight:   w,
+			bound:    max(w*float64(b[0]), w*float64(b[1]), 0),
+		})
+	}
+	slices.SortFunc(terms, func(a, b *sparseTerm) int {
+		return cmp.Compare(a.bound, b.bound)
+	})
+	upper := make([]float64, len(terms)) // total bound of the terms up to i
+	for i, t := range terms {
+		upper[i] = t.bound
+		if i > 0 {
+			upper[i] += upper[i-1]
+		}
+	}
+
+	h := &resultHeap{}
+	threshold := math.Inf(-1)
+	essential := 0
+	for {
+		for essential < len(terms) && upper[essential] < threshold {
+			essential++
+		}
+		n := math.MaxInt
+		for _, t := range terms[essential:] {
+			if t.cur < len(t.postings) {
+				n = min(n, t.postings[t.cur].n)
+			}
+		}
+		if n == math.MaxInt {
+			break
+		}
+		var score float64
+		for _, t := range terms[essential:] {
+			if t.cur < len(t.postings) && t.postings[t.cur].n == n {
+				score += t.weight * float64(t.postings[t.cur].value)
+				t.cur++
+			}
+		}
+		for i := essential - 1; i >= 0 && score+upper[i] >= threshold; i-- {
+			t := terms[i]
+			t.cur += sort.Search(len(t.postings)-t.cur, func(k int) bool {
+				return t.postings[t.cur+k].n >= n
+			})
+			if t.cur < len(t.postings) && t.postings[t.cur].n == n {
+				score += t.weight * float64(t.postings[t.cur].value)
+			}
+		}
+		if deleted[n] {
+			continue
+		}
+		d := Distance{N: n, Value: float32(score)}
+		if limit <= 0 || h.Len() < limit {
+			heap.Push(h, d)
+		} else if h.worse((*h)[0], d) {
+			(*h)[0] = d
+			heap.Fix(h, 0)
+		}
+		if limit > 0 && h.Len() == limit {
+			threshold = float64((*h)[0].Value)
+		}
+	}
+	return *h
+}
+
+// resultHeap keeps the worst result on the top, equal values are ordered by record number
+type resultHeap []Distance
+
+func (h resultHeap) worse(a, b Distance) bool {
+	return a.Value < b.Value || a.Value == b.Value && a.N > b.N
+}
+
+func (h resultHeap) Len() int           { return len(h) }
+func (h resultHeap) Less(i, j int) bool { return h.worse(h[i], h[j]) }
+func (h resultHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x any)        { *h = append(*h, x.(Distance)) }
+
+func (h *resultHeap) Pop() any {
+	old := *h
+	d := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return d
+}
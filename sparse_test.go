@@ -0,0 +1,186 @@
+package vech
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestSparseVectorEncoding(t *testing.T) {
+	v := &SparseVector{Indices: []uint32{700, 3, 40000}, Values: []float32{0.5, 1.25, -2}}
+	data, err := encodeSparse(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	section, rest, err := splitSection(data)
+	if err != nil || rest != nil {
+		t.Fatalf("unexpected section: %v, rest %v", err, rest)
+	}
+	decoded, err := decodeSparse(section)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &SparseVector{Indices: []uint32{3, 700, 40000}, Values: []float32{1.25, 0.5, -2}}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("decoded vector %v does not match to expected: %v", decoded, expected)
+	}
+	for _, invalid := range []*SparseVector{{Indices: []uint32{1, 2}, Values: []float32{1}}, {Indices: []uint32{5, 5}, Values: []float32{1, 2}}} {
+		if _, err = encodeSparse(invalid); !errors.Is(err, ErrSparseVector) {
+			t.Fatalf("error expected to be ErrSparseVector, returned: %v", err)
+		}
+	}
+}
+
+func randomSparse(rnd *rand.Rand, dims, entries int) *SparseVector {
+	v := &SparseVector{}
+	for _, index := range rnd.Perm(dims)[:entries] {
+		v.Indices = append(v.Indices, uint32(index))
+		v.Values = append(v.Values, rnd.Float32())
+	}
+	return v
+}
+
+func sparseDot(a, b *SparseVector) float32 {
+	var s float32
+	for i, x := range a.Indices {
+		for j, y := range b.Indices {
+			if x == y {
+				s += a.Values[i] * b.Values[j]
+			}
+		}
+	}
+	return s
+}
+
+func TestSparseSearch(t *testing.T) {
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, StorageType: Memory, SegmentSize: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.OpenCollection("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SetCompression(Flate, 6); err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	chunks := randomChunks(60, 4)
+	sparse := make([]*SparseVector, len(chunks))
+	for i, d := range chunks {
+		rec := Record{Vector: d.vector, Data: d.data}
+		// every third record has no sparse vector
+		if i%3 != 2 {
+			sparse[i] = randomSparse(rnd, 30, 1+rnd.Intn(8))
+			rec.Sparse = sparse[i]
+		}
+		if err = c.AddRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.Segments() < 2 {
+		t.Fatal("collection expected to have several segments")
+	}
+	rec, err := c.Get(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Sparse == nil || len(rec.Sparse.Indices) != len(sparse[4].Indices) || !bytes.Equal(rec.Data, chunks[4].data) {
+		t.Fatalf("unexpected record with sparse vector: %+v", rec)
+	}
+	ix, err := c.Index(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := c.Data(ix.Position, ix.Size); err != nil || !bytes.Equal(data, chunks[4].data) {
+		t.Fatalf("data of record with sparse vector %q does not match to payload: %v", data, err)
+	}
+
+	ctx := context.Background()
+	check := func(query *SparseVector, limit int) {
+		t.Helper()
+		res, err := c.SparseSearch(ctx, query, &SearchOptions{Limit: limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected []Distance
+		for i, v := range sparse {
+			if v == nil || i == 7 {
+				continue
+			}
+			if slices.ContainsFunc(v.Indices, func(index uint32) bool { return slices.Contains(query.Indices, index) }) {
+				expected = append(expected, Distance{N: i, Value: sparseDot(query, v)})
+			}
+		}
+		sortByScore(expected)
+		if limit > 0 && len(expected) > limit {
+			expected = expected[:limit]
+		}
+		if len(res) != len(expected) {
+			t.Fatalf("expected %d results, actual: %d", len(expected), len(res))
+		}
+		for i := range res {
+			// scores are compared, since records of equal scores summed in different order may swap
+			if math.Abs(float64(res[i].Value-expected[i].Value)) > 1e-5 {
+				t.Fatalf("result %d: %+v does not match to expected: %+v", i, res[i], expected[i])
+			}
+		}
+	}
+	if err = c.Delete(7); err != nil {
+		t.Fatal(err)
+	}
+	for range 20 {
+		query := randomSparse(rnd, 30, 1+rnd.Intn(6))
+		for _, limit := range []int{1, 5, 0} {
+			check(query, limit)
+		}
+	}
+	// records added after the index is built are found
+	sparse = append(sparse, &SparseVector{Indices: []uint32{100}, Values: []float32{3}})
+	if err = c.AddRecord(Record{Vector: chunks[0].vector, Sparse: sparse[60]}); err != nil {
+		t.Fatal(err)
+	}
+	check(&SparseVector{Indices: []uint32{100, 1}, Values: []float32{1, 0.5}}, 2)
+	if _, err = c.SparseSearch(ctx, &SparseVector{Indices: []uint32{1}}, &SearchOptions{}); !errors.Is(err, ErrSparseVector) {
+		t.Fatalf("error expected to be ErrSparseVector, returned: %v", err)
+	}
+
+	res, err := c.HybridSearch(ctx, chunks[5].vector, "", &HybridOptions{Limit: 3, Sparse: sparse[60]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].Scores.SparseRank != 1 && res[0].Scores.VectorRank != 1 {
+		t.Fatalf("best sparse or vector match expected to be first, results: %+v", res[0].Scores)
+	}
+	found := false
+	for _, r := range res {
+		found = found || r.N == 60 && r.Scores.Sparse == 9
+	}
+	if !found {
+		t.Fatalf("record 60 expected to be fused by its sparse score, results: %v", res)
+	}
+
+	var archive bytes.Buffer
+	if err = c.Export(&archive); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := db.OpenCollection("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = imported.Import(&archive); err != nil {
+		t.Fatal(err)
+	}
+	rec, err = imported.Get(imported.Len() - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rec.Sparse, sparse[60]) {
+		t.Fatalf("imported sparse vector %v does not match to original: %v", rec.Sparse, sparse[60])
+	}
+}
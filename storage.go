@@ -7,6 +7,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 )
 
 var (
@@ -27,6 +30,7 @@ type StorageType int
 const (
 	FileSystem StorageType = iota
 	Memory
+	S3
 )
 
 type storage interface {
@@ -35,12 +39,187 @@ type storage interface {
 	closeWriter() error
 	reader(position int) (io.Reader, error)
 	closeReader() error
+	readAt(p []byte, position int) (int, error) // positional read safe for concurrent use
+	sync() error                                // commits written data to stable storage
+	truncate(size int) error                    // drops the content after size
+	writeAt(p []byte, position int) error       // overwrites the content at position not greater than size
+}
+
+// backend provides named storages and small metadata files of the database
+type backend interface {
+	open(name string) (storage, error)
+	readFile(name string) ([]byte, error)
+	writeFile(name string, data []byte) error
+	remove(name string) error
+	rename(from, to string) error
+	list() ([]string, error)
+}
+
+type fsBackend struct {
+	path     string
+	readOnly bool // files are never created or modified
+}
+
+func (b *fsBackend) open(name string) (storage, error) {
+	if b.readOnly {
+		return openReadOnlyFileStorage(b.path + "/" + name)
+	}
+	return openFileStorage(b.path + "/" + name)
+}
+
+func (b *fsBackend) readFile(name string) ([]byte, error) {
+	return os.ReadFile(b.path + "/" + name)
+}
+
+// writeFile replaces the file atomically
+func (b *fsBackend) writeFile(name string, data []byte) error {
+	path := b.path + "/" + name
+	if b.readOnly {
+		return fmt.Errorf("%w: %s", ErrReadOnly, path)
+	}
+	f, err := os.CreateTemp(b.path, filepath.Base(name)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("%w: %s %s", ErrCreateFile, err.Error(), path)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("%w: %s %s", ErrCreateFile, err.Error(), path)
+	}
+	return nil
+}
+
+func (b *fsBackend) remove(name string) error {
+	if b.readOnly {
+		return fmt.Errorf("%w: %s/%s", ErrReadOnly, b.path, name)
+	}
+	err := os.Remove(b.path + "/" + name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (b *fsBackend) rename(from, to string) error {
+	if b.readOnly {
+		return fmt.Errorf("%w: %s/%s", ErrReadOnly, b.path, from)
+	}
+	return os.Rename(b.path+"/"+from, b.path+"/"+to)
+}
+
+// list returns names of all database files
+func (b *fsBackend) list() ([]string, error) {
+	entries, err := os.ReadDir(b.path)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && e.Name() != lockFile {
+			out = append(out, e.Name())
+		}
+	}
+	return out, nil
+}
+
+type memBackend struct {
+	mu       sync.Mutex
+	storages map[string]*memoryStorage
+	files    map[string][]byte
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{
+		storages: make(map[string]*memoryStorage),
+		files:    make(map[string][]byte),
+	}
+}
+
+func (b *memBackend) open(name string) (storage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ms, ok := b.storages[name]
+	if !ok {
+		ms = newMemoryStorage()
+		b.storages[name] = ms
+	}
+	return ms, nil
+}
+
+func (b *memBackend) readFile(name string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if data, ok := b.files[name]; ok {
+		return data, nil
+	}
+	if ms, ok := b.storages[name]; ok {
+		return ms.bytes(), nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (b *memBackend) writeFile(name string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (b *memBackend) remove(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.storages, name)
+	delete(b.files, name)
+	return nil
+}
+
+func (b *memBackend) rename(from, to string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ms, ok := b.storages[from]; ok {
+		b.storages[to] = ms
+		delete(b.storages, from)
+		return nil
+	}
+	if data, ok := b.files[from]; ok {
+		b.files[to] = data
+		delete(b.files, from)
+		return nil
+	}
+	return os.ErrNotExist
+}
+
+func (b *memBackend) list() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]string, 0, len(b.storages)+len(b.files))
+	for name := range b.storages {
+		out = append(out, name)
+	}
+	for name := range b.files {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out, nil
 }
 
 type fileStorage struct {
-	path string
-	rdf  *os.File
-	wrf  *os.File
+	path     string
+	readOnly bool
+	rdf      *os.File
+	wrf      *os.File
+	waf      *os.File   // file of positional writes, the writer appends only
+	mu       sync.Mutex // guards raf opening
+	raf      *os.File   // random access file used by readAt
 }
 
 func openFileStorage(path string) (*fileStorage, error) {
@@ -67,6 +246,18 @@ func openFileStorage(path string) (*fileStorage, error) {
 	return &fs, nil
 }
 
+// openReadOnlyFileStorage opens storage without creating the file, absent file is empty storage
+func openReadOnlyFileStorage(path string) (*fileStorage, error) {
+	stat, err := os.Stat(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil && stat.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrPathIsDir, path)
+	}
+	return &fileStorage{path: path, readOnly: true}, nil
+}
+
 func (fs *fileStorage) size() int {
 	s, err := os.Stat(fs.path)
 	if err != nil {
@@ -76,6 +267,9 @@ func (fs *fileStorage) size() int {
 }
 
 func (fs *fileStorage) writer() (io.Writer, error) {
+	if fs.readOnly {
+		return nil, fmt.Errorf("%w: %s", ErrReadOnly, fs.path)
+	}
 	if fs.wrf != nil {
 		return fs.wrf, nil
 	}
@@ -88,12 +282,16 @@ func (fs *fileStorage) writer() (io.Writer, error) {
 }
 
 func (fs *fileStorage) closeWriter() error {
-	if fs.wrf == nil {
-		return nil
+	var errs []error
+	if fs.wrf != nil {
+		errs = append(errs, fs.wrf.Close())
+		fs.wrf = nil
 	}
-	err := fs.wrf.Close()
-	fs.wrf = nil
-	return err
+	if fs.waf != nil {
+		errs = append(errs, fs.waf.Close())
+		fs.waf = nil
+	}
+	return errors.Join(errs...)
 }
 
 func (fs *fileStorage) reader(position int) (io.Reader, error) {
@@ -119,15 +317,76 @@ func (fs *fileStorage) reader(position int) (io.Reader, error) {
 }
 
 func (fs *fileStorage) closeReader() error {
-	if fs.rdf == nil {
-		return nil
+	var errs []error
+	if fs.rdf != nil {
+		errs = append(errs, fs.rdf.Close())
+		fs.rdf = nil
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.raf != nil {
+		errs = append(errs, fs.raf.Close())
+		fs.raf = nil
+	}
+	return errors.Join(errs...)
+}
+
+func (fs *fileStorage) readAt(p []byte, position int) (int, error) {
+	fs.mu.Lock()
+	if fs.raf == nil {
+		f, err := os.Open(fs.path)
+		if err != nil {
+			fs.mu.Unlock()
+			return 0, fmt.Errorf("%w: %s %s", ErrFileRead, err.Error(), fs.path)
+		}
+		fs.raf = f
+	}
+	f := fs.raf
+	fs.mu.Unlock()
+	n, err := f.ReadAt(p, int64(position))
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	return n, err
+}
+
+func (fs *fileStorage) sync() error {
+	if fs.wrf != nil {
+		return fs.wrf.Sync()
+	}
+	if fs.waf != nil {
+		return fs.waf.Sync()
+	}
+	return nil
+}
+
+func (fs *fileStorage) truncate(size int) error {
+	if fs.readOnly {
+		return fmt.Errorf("%w: %s", ErrReadOnly, fs.path)
+	}
+	return os.Truncate(fs.path, int64(size))
+}
+
+func (fs *fileStorage) writeAt(p []byte, position int) error {
+	if fs.readOnly {
+		return fmt.Errorf("%w: %s", ErrReadOnly, fs.path)
+	}
+	if position > fs.size() {
+		return fmt.Errorf("%w: position is greater than storage size", ErrSeek)
+	}
+	if fs.waf == nil {
+		f, err := os.OpenFile(fs.path, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("%w: %s %s", ErrFileAppend, err.Error(), fs.path)
+		}
+		fs.waf = f
 	}
-	err := fs.rdf.Close()
-	fs.rdf = nil
+	_, err := fs.waf.WriteAt(p, int64(position))
 	return err
 }
 
 type memoryStorage struct {
+	mu   sync.RWMutex
 	data []byte
 }
 
@@ -139,14 +398,25 @@ func newMemoryStorage() *memoryStorage {
 
 // Write implements io.Writer
 func (ms *memoryStorage) Write(p []byte) (n int, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
 	ms.data = append(ms.data, p...)
 	return len(p), nil
 }
 
 func (ms *memoryStorage) size() int {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 	return len(ms.data)
 }
 
+// bytes returns copy of the storage content
+func (ms *memoryStorage) bytes() []byte {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return append([]byte(nil), ms.data...)
+}
+
 func (ms *memoryStorage) writer() (io.Writer, error) {
 	return ms, nil
 }
@@ -159,9 +429,12 @@ func (ms *memoryStorage) reader(position int) (io.Reader, error) {
 	if position < 0 {
 		panic("negative file position")
 	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 	if position >= len(ms.data) {
 		return nil, fmt.Errorf("%w: position is greater than storage size", ErrSeek)
 	}
+	// appends never modify the bytes visible to the reader
 	return bytes.NewReader(ms.data[position:]), nil
 }
 
@@ -169,6 +442,47 @@ func (ms *memoryStorage) closeReader() error {
 	return nil
 }
 
+func (ms *memoryStorage) readAt(p []byte, position int) (int, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if position < 0 || position >= len(ms.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, ms.data[position:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (ms *memoryStorage) sync() error {
+	return nil
+}
+
+func (ms *memoryStorage) truncate(size int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if size < len(ms.data) {
+		// capacity is cut, so the next append does not overwrite bytes visible to readers
+		ms.data = ms.data[:size:size]
+	}
+	return nil
+}
+
+func (ms *memoryStorage) writeAt(p []byte, position int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if position > len(ms.data) {
+		return fmt.Errorf("%w: position is greater than storage size", ErrSeek)
+	}
+	if position < len(ms.data) {
+		// the content is copied, overwritten bytes may be visible to readers
+		ms.data = ms.data[:position:position]
+	}
+	ms.data = append(ms.data, p...)
+	return nil
+}
+
 func checkOrCreateDir(path string) error {
 	dir, err := os.Stat(path)
 	if err != nil {
@@ -187,16 +501,17 @@ func checkOrCreateDir(path string) error {
 }
 
 func readConfig(path string) (*config, error) {
-	f, err := os.Open(path)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, ErrConfigAbsent
 		}
 		return nil, fmt.Errorf("%w: %s", ErrReadConfig, err.Error())
 	}
-	defer f.Close()
-
-	decoder := gob.NewDecoder(f)
+	if bytes.HasPrefix(data, []byte(encMagic)) {
+		return nil, fmt.Errorf("%w: encryption key is required", ErrEncrypted)
+	}
+	decoder := gob.NewDecoder(bytes.NewReader(data))
 	var c config
 	if err := decoder.Decode(&c); err != nil {
 		return nil, fmt.Errorf("%w: %s", ErrReadConfig, err.Error())
@@ -1,6 +1,7 @@
 package vech
 
 import (
+	"bytes"
 	"errors"
 	"io"
 	"testing"
@@ -190,3 +191,92 @@ func TestReadWriteConfig(t *testing.T) {
 		t.Fatal("config read does not match config write")
 	}
 }
+
+func TestStorageReadAt(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := openFileStorage(path + "/storage.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.closeReader()
+	data := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	for _, st := range []storage{newMemoryStorage(), fs} {
+		writer, err := st.writer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = writer.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		rb := make([]byte, 4)
+		n, err := st.readAt(rb, 3)
+		if err != nil {
+			t.Fatalf("error expected to be nil, returned: %v", err)
+		}
+		if n != 4 || rb[0] != 3 || rb[3] != 6 {
+			t.Fatalf("expected to read 3-6, actual: %v", rb[:n])
+		}
+		n, err = st.readAt(rb, 8)
+		if err != io.EOF {
+			t.Fatalf("error expected to be io.EOF, returned %v", err)
+		}
+		if n != 2 || rb[0] != 8 {
+			t.Fatalf("expected to read 8-9, actual: %v", rb[:n])
+		}
+		if err = st.closeWriter(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStorageWriteAt(t *testing.T) {
+	path, err := setupDir("testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := openFileStorage(path + "/storage.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.closeReader()
+	for _, st := range []storage{newMemoryStorage(), fs} {
+		writer, err := st.writer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = writer.Write([]byte{0, 1, 2, 3, 4, 5}); err != nil {
+			t.Fatal(err)
+		}
+		before, err := st.reader(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = st.writeAt([]byte{7, 8, 9}, 4); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = writer.Write([]byte{10}); err != nil {
+			t.Fatal(err)
+		}
+		rb := make([]byte, 8)
+		if n, err := st.readAt(rb, 0); err != nil || !bytes.Equal(rb[:n], []byte{0, 1, 2, 3, 7, 8, 9, 10}) {
+			t.Fatalf("unexpected content after positional write: %v %v", rb[:n], err)
+		}
+		if err = st.writeAt([]byte{1}, 9); !errors.Is(err, ErrSeek) {
+			t.Fatalf("error expected to be ErrSeek, returned: %v", err)
+		}
+		if st == storage(fs) {
+			continue
+		}
+		// readers of memory storage keep the content they were created at
+		old, err := io.ReadAll(before)
+		if err != nil || !bytes.Equal(old, []byte{0, 1, 2, 3, 4, 5}) {
+			t.Fatalf("reader content expected to be kept: %v %v", old, err)
+		}
+	}
+	if err = fs.closeWriter(); err != nil {
+		t.Fatal(err)
+	}
+}
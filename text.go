@@ -0,0 +1,355 @@
+package vech
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Full-text search
+//
+// The collection with TextField option indexes the field of its records with inverted index
+// scored by BM25. The index is kept in memory, it is built per segment by the first text search
+// outside the collection lock and records added later are indexed incrementally by following searches.
+
+var ErrNoTextField = errors.New("collection has no text field")
+
+const (
+	bm25K1 = 1.2  // term frequency saturation
+	bm25B  = 0.75 // document length normalization
+	rrfK   = 60   // default rank constant of reciprocal rank fusion
+)
+
+// Fusion is the method combining vector and keyword rankings of hybrid search
+type Fusion int
+
+const (
+	RRF         Fusion = iota // reciprocal rank fusion, weight/(k+rank) summed over rankings
+	WeightedSum               // weighted sum of scores normalized to [0, 1]
+)
+
+// HybridOptions control the hybrid search, nil options are the zero value
+type HybridOptions struct {
+	Limit        int           // maximal amount of results, 0 means all
+	Candidates   int           // amount of results of every ranking fused, 0 means Limit
+	Fusion       Fusion        // method of fusion
+	RRFK         int           // rank constant of RRF, 0 means 60
+	VectorWeight float32       // weight of vector ranking, all weights 0 mean equal weights
+	TextWeight   float32       // weight of keyword ranking
+	Sparse       *SparseVector // sparse query vector, its dot product ranking is fused when given
+	SparseWeight float32       // weight of sparse ranking
+	WithData     bool          // read data of the results
+	WithVectors  bool          // include vectors of the results
+}
+
+// Scores are component scores of the hybrid search result
+type Scores struct {
+	Vector     float32 // vector metric value, 0 if the record is not among vector candidates
+	Text       float32 // BM25 score, 0 if the record does not match the query
+	Sparse     float32 // sparse dot product, 0 if the record is not among sparse candidates
+	VectorRank int     // rank in vector candidates starting from 1, 0 if absent
+	TextRank   int     // rank in keyword candidates starting from 1, 0 if absent
+	SparseRank int     // rank in sparse candidates starting from 1, 0 if absent
+}
+
+// tokenize splits the text into lower case words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+type posting struct {
+	n    int // local record number
+	freq int // term frequency in the record
+}
+
+// textIndex is inverted index of the text field of segment records
+type textIndex struct {
+	mu       sync.Mutex // guards indexing by concurrent searches
+	postings map[string][]posting
+	lengths  []int // amount of terms of indexed records
+	total    int   // amount of terms of all indexed records
+}
+
+func newTextIndex() *textIndex {
+	return &textIndex{postings: make(map[string][]posting)}
+}
+
+// SetTextField sets the record field indexed for text search, empty name disables the index
+func (c *Collection) SetTextField(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev := c.textField
+	c.textField = name
+	if err := writeManifest(c.backend, c.name, c.manifest()); err != nil {
+		c.textField = prev
+		return err
+	}
+	for _, s := range c.segments {
+		s.text = newTextIndex()
+	}
+	return nil
+}
+
+// indexText indexes segment records added since the previous call, c.mu has to be held
+func (c *Collection) indexText(ctx context.Context, seg *segment) error {
+	it := segmentIterator{seg: seg, index: seg.index, dataSize: seg.dataSize, dicts: c.dictionaries}
+	return seg.text.index(ctx, &it, c.textField)
+}
+
+// buildText indexes records of all segments outside the collection lock, so the first text search does not
+// block writers. Segments are pinned, so merges do not close them meanwhile. Records added after the segments
+// were captured are indexed by the search.
+func (c *Collection) buildText(ctx context.Context) error {
+	c.mu.RLock()
+	field := c.textField
+	if field == "" {
+		c.mu.RUnlock()
+		return nil
+	}
+	segments := slices.Clone(c.segments)
+	its := make([]segmentIterator, len(segments))
+	texts := make([]*textIndex, len(segments))
+	for i, s := range segments {
+		its[i] = segmentIterator{seg: s, index: s.index, dataSize: s.dataSize, dicts: c.dictionaries}
+		texts[i] = s.text
+	}
+	c.pin(segments)
+	c.mu.RUnlock()
+	defer c.unpin(segments)
+	for i := range its {
+		if err := texts[i].index(ctx, &its[i], field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index indexes the field of the records added to the segment state since the previous call. Deleted records
+// are indexed too, they are skipped by search.
+func (ix *textIndex) index(ctx context.Context, it *segmentIterator, field string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for n := len(ix.lengths); n < len(it.index)/it.seg.recordSize; n++ {
+		if n%cancelCheckStep == cancelCheckStep-1 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		rec, err := it.record(n)
+		if err != nil {
+			return err
+		}
+		terms := tokenize(string(rec.Fields[field]))
+		freqs := make(map[string]int, len(terms))
+		for _, t := range terms {
+			freqs[t]++
+		}
+		for t, f := range freqs {
+			ix.postings[t] = append(ix.postings[t], posting{n: n, freq: f})
+		}
+		ix.lengths = append(ix.lengths, len(terms))
+		ix.total += len(terms)
+	}
+	return nil
+}
+
+// TextSearch returns records matching the query ordered by descending BM25 score.
+// Order option is ignored, the score of the result is its Value.
+func (c *Collection) TextSearch(ctx context.Context, query string, opt *SearchOptions) ([]Distance, error) {
+	if opt == nil {
+		opt = &SearchOptions{}
+	}
+	if err := c.buildText(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	res, err := c.textSearch(ctx, query, opt.Limit)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.fillResults(res, opt.WithData, opt.WithVectors); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// textSearch scores records by BM25 with statistics of all segments, c.mu has to be held
+func (c *Collection) textSearch(ctx context.Context, query string, limit int) ([]Distance, error) {
+	if c.textField == "" {
+		return nil, ErrNoTextField
+	}
+	terms := tokenize(query)
+	docs, total := 0, 0
+	df := make(map[string]int, len(terms))
+	for _, seg := range c.segments {
+		if err := c.indexText(ctx, seg); err != nil {
+			return nil, err
+		}
+		// indexing is complete and builders index records captured under the lock only, so the index
+		// is not modified while the collection lock is held
+		docs += len(seg.text.lengths)
+		total += seg.text.total
+		for _, t := range terms {
+			df[t] += len(seg.text.postings[t])
+		}
+	}
+	if total == 0 {
+		return nil, nil
+	}
+	avg := float64(total) / float64(docs)
+	var res []Distance
+	for _, seg := range c.segments {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		scores := make(map[int]float64)
+		for t := range df {
+			idf := math.Log(1 + (float64(docs-df[t])+0.5)/(float64(df[t])+0.5))
+			for _, p := range seg.text.postings[t] {
+				if seg.deleted[p.n] {
+					continue
+				}
+				tf := float64(p.freq)
+				norm := bm25K1 * (1 - bm25B + bm25B*float64(seg.text.lengths[p.n])/avg)
+				scores[p.n] += idf * tf * (bm25K1 + 1) / (tf + norm)
+			}
+		}
+		for n, score := range scores {
+			pos, size := seg.entry(n)
+			res = append(res, Distance{N: seg.base + n, Value: float32(score), Position: seg.dataBase + pos, Size: size})
+		}
+	}
+	sortByScore(res)
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// HybridSearch fuses vector ranking of the vector, BM25 ranking of the query and dot product ranking of the Sparse
+// option. Keyword ranking is skipped for empty query. The results are ordered by descending fused score kept
+// in Value, component scores and ranks are returned in Scores.
+func (c *Collection) HybridSearch(ctx context.Context, vector []float32, query string, opt *HybridOptions) ([]Distance, error) {
+	if opt == nil {
+		opt = &HybridOptions{}
+	}
+	if len(vector) != c.vectorSize {
+		return nil, fmt.Errorf("%w: collection vector size: %d, provided vector size: %d", ErrVectorSize, c.vectorSize, len(vector))
+	}
+	candidates := opt.Candidates
+	if candidates == 0 {
+		candidates = opt.Limit
+	}
+	wv, wt, ws := float64(opt.VectorWeight), float64(opt.TextWeight), float64(opt.SparseWeight)
+	if wv == 0 && wt == 0 && ws == 0 {
+		wv, wt, ws = 1, 1, 1
+	}
+	k := opt.RRFK
+	if k == 0 {
+		k = rrfK
+	}
+	if query != "" {
+		if err := c.buildText(ctx); err != nil {
+			return nil, err
+		}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var keyword, sparse []Distance
+	var err error
+	if query != "" {
+		if keyword, err = c.textSearch(ctx, query, candidates); err != nil {
+			return nil, err
+		}
+	}
+	if opt.Sparse != nil {
+		if sparse, err = c.sparseSearch(ctx, opt.Sparse, candidates); err != nil {
+			return nil, err
+		}
+	}
+	similar, err := c.search(ctx, vector, c.metric.closest(), candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	fused := make(map[int]*Distance)
+	scores := make(map[int]float64)
+	fuse := func(ranking []Distance, order SortType, weight float64, set func(s *Scores, value float32, rank int)) {
+		norm := normalizer(ranking, order)
+		for i, d := range ranking {
+			r, ok := fused[d.N]
+			if !ok {
+				r = &Distance{N: d.N, Position: d.Position, Size: d.Size, Scores: &Scores{}}
+				fused[d.N] = r
+			}
+			set(r.Scores, d.Value, i+1)
+			if opt.Fusion == WeightedSum {
+				scores[d.N] += weight * norm(d.Value)
+			} else {
+				scores[d.N] += weight / float64(k+i+1)
+			}
+		}
+	}
+	fuse(similar, c.metric.closest(), wv, func(s *Scores, value float32, rank int) {
+		s.Vector, s.VectorRank = value, rank
+	})
+	fuse(keyword, SortDesc, wt, func(s *Scores, value float32, rank int) {
+		s.Text, s.TextRank = value, rank
+	})
+	fuse(sparse, SortDesc, ws, func(s *Scores, value float32, rank int) {
+		s.Sparse, s.SparseRank = value, rank
+	})
+	res := make([]Distance, 0, len(fused))
+	for n, r := range fused {
+		r.Value = float32(scores[n])
+		res = append(res, *r)
+	}
+	sortByScore(res)
+	if opt.Limit > 0 && len(res) > opt.Limit {
+		res = res[:opt.Limit]
+	}
+	if err := c.fillResults(res, opt.WithData, opt.WithVectors); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// sortByScore orders the results by descending value, equal values are ordered by record number
+func sortByScore(res []Distance) {
+	slices.SortFunc(res, func(a, b Distance) int {
+		if c := cmp.Compare(b.Value, a.Value); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.N, b.N)
+	})
+}
+
+// normalizer returns min-max normalization of result values to [0, 1], the best value is mapped to 1
+func normalizer(res []Distance, order SortType) func(v float32) float64 {
+	if len(res) == 0 {
+		return func(float32) float64 { return 0 }
+	}
+	lo, hi := res[0].Value, res[0].Value
+	for _, d := range res {
+		lo, hi = min(lo, d.Value), max(hi, d.Value)
+	}
+	return func(v float32) float64 {
+		if hi == lo {
+			return 1
+		}
+		x := float64(v-lo) / float64(hi-lo)
+		if order == SortAsc {
+			return 1 - x
+		}
+		return x
+	}
+}
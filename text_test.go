@@ -0,0 +1,158 @@
+package vech
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens := tokenize("SKU-1234: Größe 42, the QUICK fox!")
+	expected := []string{"sku", "1234", "größe", "42", "the", "quick", "fox"}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Fatalf("tokens %v do not match to expected: %v", tokens, expected)
+	}
+}
+
+func TestHybridSearch(t *testing.T) {
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, StorageType: Memory, SegmentSize: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.CreateCollection("foo", &CollectionOptions{TextField: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := []string{
+		"red apples and green apples",
+		"product code XK-9041 spare part",
+		"green tea",
+		"apples are sweet fruits of many colours",
+		"",
+		"red wine from red grapes",
+	}
+	chunks := randomChunks(20, 4)
+	for i, d := range chunks {
+		rec := Record{Vector: d.vector, Data: d.data}
+		if i < len(texts) {
+			rec.Fields = map[string][]byte{"text": []byte(texts[i])}
+		}
+		if err = c.AddRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	res, err := c.TextSearch(ctx, "xk-9041", &SearchOptions{WithData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].N != 1 || !reflect.DeepEqual(res[0].Data, chunks[1].data) {
+		t.Fatalf("product code expected to match record 1, results: %v", res)
+	}
+	res, err = c.TextSearch(ctx, "red apples", &SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := make([]int, len(res))
+	for i, r := range res {
+		ns[i] = r.N
+	}
+	if !reflect.DeepEqual(ns, []int{0, 5, 3}) {
+		t.Fatalf("unexpected order of text results: %v", res)
+	}
+
+	// records added after the index is built and deleted records are taken into account
+	if err = c.AddRecord(Record{Vector: chunks[0].vector, Fields: map[string][]byte{"text": []byte("XK-9041 manual")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	res, err = c.TextSearch(ctx, "XK 9041", &SearchOptions{Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].N != 20 {
+		t.Fatalf("product code expected to match record 20 only, results: %v", res)
+	}
+
+	for _, fusion := range []Fusion{RRF, WeightedSum} {
+		res, err = c.HybridSearch(ctx, chunks[2].vector, "green", &HybridOptions{Limit: 3, Candidates: 5, Fusion: fusion})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 3 {
+			t.Fatalf("hybrid search expected to return 3 results, actual: %v", res)
+		}
+		for i := 1; i < len(res); i++ {
+			if res[i].Value > res[i-1].Value {
+				t.Fatalf("hybrid results expected to be ordered by descending score: %v", res)
+			}
+		}
+		if res[0].Scores.VectorRank != 1 || res[0].Scores.TextRank == 0 {
+			t.Fatalf("record matching both vector and query expected to be first, results: %+v", res[0].Scores)
+		}
+	}
+	res, err = c.HybridSearch(ctx, chunks[9].vector, "tea", &HybridOptions{Limit: 1, TextWeight: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].N != 2 || res[0].Scores.Text == 0 || res[0].Scores.Vector != 0 {
+		t.Fatalf("keyword only weight expected to return record 2, results: %+v", res[0])
+	}
+
+	if err = c.SetTextField(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.TextSearch(ctx, "apples", &SearchOptions{}); !errors.Is(err, ErrNoTextField) {
+		t.Fatalf("error expected to be ErrNoTextField, returned: %v", err)
+	}
+}
+
+func TestTextSearchConcurrentWrites(t *testing.T) {
+	db, err := CreateDb(&CreateDbOptions{VectorSize: 4, StorageType: Memory, SegmentSize: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.CreateCollection("foo", &CollectionOptions{TextField: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(chunks []testdata) error {
+		for _, d := range chunks {
+			if err := c.AddRecord(Record{Vector: d.vector, Fields: map[string][]byte{"text": []byte("green tea")}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err = add(randomChunks(100, 4)); err != nil {
+		t.Fatal(err)
+	}
+	// the index is built while records are added and segments are merged
+	done := make(chan error)
+	go func() {
+		if err := add(randomChunks(100, 4)); err != nil {
+			done <- err
+			return
+		}
+		done <- c.Merge(&MergePolicy{SegmentsPerTier: 2})
+	}()
+	ctx := context.Background()
+	for range 20 {
+		if _, err = c.TextSearch(ctx, "tea", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.TextSearch(ctx, "tea", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 200 {
+		t.Fatalf("all records expected to match, actual: %d", len(res))
+	}
+}